@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	socket "github.com/akillmer/go-socket"
+	db "github.com/akillmer/riptide/database"
+)
+
+// The activity feed is a capped, persistent ring of notable events across
+// all torrents. Each event goes out as MsgActivity when it happens, and a
+// reconnecting client pulls the backlog with MsgActivityHistory to fill its
+// notification drawer.
+
+// ActivityEvent is one entry in the server-wide activity feed
+type ActivityEvent struct {
+	Time   int64  `json:"time"`
+	Hash   string `json:"hash,omitempty"`
+	Name   string `json:"name,omitempty"`
+	Event  string `json:"event"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// maxActivityEvents caps the feed, old entries fall off
+const maxActivityEvents = 200
+
+// activityKey is the single key the feed lives under
+const activityKey = "feed"
+
+// activityMu serializes read-modify-write of the feed, events come from the
+// API loop and every torrent manager
+var activityMu = sync.Mutex{}
+
+// feedEvents are the per-torrent history events that also belong in the feed
+var feedEvents = map[string]bool{
+	"added":    true,
+	"finished": true,
+	"removed":  true,
+	"errored":  true,
+}
+
+// appendActivity records an event in the feed and broadcasts it
+func appendActivity(hash, name, event, detail string) {
+	entry := &ActivityEvent{
+		Time:   time.Now().Unix(),
+		Hash:   hash,
+		Name:   name,
+		Event:  event,
+		Detail: detail,
+	}
+
+	activityMu.Lock()
+	events := getActivity()
+	events = append(events, entry)
+	if len(events) > maxActivityEvents {
+		events = events[len(events)-maxActivityEvents:]
+	}
+	if err := db.Put(db.BucketActivity, activityKey, events); err != nil {
+		log.Printf("failed to save activity feed: %v", err)
+	}
+	activityMu.Unlock()
+
+	socket.Broadcast(MsgActivity, entry)
+}
+
+// getActivity returns the recorded feed, oldest first
+func getActivity() []*ActivityEvent {
+	events := []*ActivityEvent{}
+	if buf, err := db.Get(db.BucketActivity, activityKey); err == nil {
+		if err := json.Unmarshal(buf, &events); err != nil {
+			log.Printf("failed to read activity feed: %v", err)
+		}
+	}
+	return events
+}
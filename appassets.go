@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+//go:generate go run gen_appassets.go
+
+// Embedded frontend. The built React app can be baked into the binary as a
+// map of gzipped files, the way strelaysrv ships its GUI, so a deployment is
+// one binary with nothing alongside it. Run the generator against a built
+// app directory to produce appassets_gen.go, which registers every file
+// here through its init. A real directory at -app still wins, so a frontend
+// can be iterated on without rebuilding the server.
+
+// appAssets maps a request path to the gzipped bytes of the built file,
+// empty unless a generated bundle is compiled in
+var appAssets = map[string][]byte{}
+
+// registerAppAssets is called by the generated bundle's init
+func registerAppAssets(files map[string][]byte) {
+	for name, data := range files {
+		appAssets[name] = data
+	}
+}
+
+// appHandler picks how the frontend is served: a directory that actually
+// exists at -app overrides everything, then the embedded bundle, and with
+// neither the old FileServer behavior stands so the 404s look familiar
+func appHandler(dir string) http.Handler {
+	if fi, err := os.Stat(dir); err == nil && fi.IsDir() {
+		return http.FileServer(http.Dir(dir))
+	}
+	if len(appAssets) > 0 {
+		return http.HandlerFunc(serveAppAsset)
+	}
+	return http.FileServer(http.Dir(dir))
+}
+
+// serveAppAsset writes one embedded file, passing the stored gzip straight
+// through when the browser accepts it. Paths the bundle doesn't have fall
+// back to the app shell so client-side routes survive a reload.
+func serveAppAsset(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	if name == "" {
+		name = "index.html"
+	}
+
+	buf, ok := appAssets[name]
+	if !ok {
+		name = "index.html"
+		if buf, ok = appAssets[name]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	if ctype := mime.TypeByExtension(filepath.Ext(name)); ctype != "" {
+		w.Header().Set("Content-Type", ctype)
+	}
+
+	if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		w.Header().Set("Content-Encoding", "gzip")
+	} else {
+		gr, err := gzip.NewReader(bytes.NewReader(buf))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if buf, err = io.ReadAll(gr); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		gr.Close()
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(buf)))
+	w.Write(buf)
+}
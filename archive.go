@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	db "github.com/akillmer/riptide/database"
+)
+
+// ArchivedTorrent keeps enough of a removed torrent around to re-add it later
+type ArchivedTorrent struct {
+	Hash        string `json:"hash"`
+	Name        string `json:"name"`
+	Magnet      string `json:"magnet"`
+	LabelID     string `json:"labelID"`
+	TimeRemoved int64  `json:"timeRemoved"`
+}
+
+// archiveTorrent stores the torrent's magnet and metadata before it's removed
+func archiveTorrent(info *TorrentInfo) error {
+	archived := &ArchivedTorrent{
+		Hash:        info.Hash,
+		Name:        info.Name,
+		Magnet:      info.Magnet,
+		LabelID:     info.LabelID,
+		TimeRemoved: time.Now().Unix(),
+	}
+	return db.Put(db.BucketArchive, archived.Hash, archived)
+}
+
+// GetAllArchivedTorrents from the database
+func GetAllArchivedTorrents() ([]*ArchivedTorrent, error) {
+	buf := db.All(db.BucketArchive)
+	if buf == nil {
+		return nil, nil
+	}
+
+	all := make([]*ArchivedTorrent, len(buf))
+	for i, b := range buf {
+		archived := &ArchivedTorrent{}
+		if err := json.Unmarshal(b, archived); err != nil {
+			return nil, err
+		}
+		all[i] = archived
+	}
+
+	return all, nil
+}
+
+// restoreArchivedTorrent re-adds a previously removed torrent by its magnet
+// and puts its old label back
+func restoreArchivedTorrent(hash string) error {
+	archived := &ArchivedTorrent{}
+	if buf, err := db.Get(db.BucketArchive, hash); err != nil {
+		return err
+	} else if err := json.Unmarshal(buf, archived); err != nil {
+		return err
+	}
+
+	if err := addTorrentByMagnet(archived.Magnet); err != nil {
+		return err
+	}
+
+	if archived.LabelID != "" {
+		// the label may have been deleted since, that's fine
+		if _, err := GetLabel(archived.LabelID); err == nil {
+			if info, err := GetTorrentInfo(hash); err == nil {
+				info.LabelID = archived.LabelID
+				info.SaveAndBroadcast()
+			}
+		}
+	}
+
+	return db.Delete(db.BucketArchive, hash)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"sync"
+)
+
+// Priority classes for dividing the global bandwidth caps between active
+// torrents, instead of first-come-first-served
+const (
+	PriorityHigh   = "HIGH"
+	PriorityNormal = "NORMAL"
+	PriorityLow    = "LOW"
+)
+
+// priorityWeight of each class, a high torrent gets four times the share of
+// a low one. An unset priority counts as normal.
+var priorityWeight = map[string]int64{
+	PriorityHigh:   4,
+	PriorityNormal: 2,
+	PriorityLow:    1,
+}
+
+// activePriorities tracks the priority class of every running torrent so the
+// allocator knows how to split the caps
+var activePriorities = sync.Map{}
+
+func validPriority(priority string) bool {
+	_, ok := priorityWeight[priority]
+	return ok
+}
+
+func setActivePriority(hash, priority string) {
+	if !validPriority(priority) {
+		priority = PriorityNormal
+	}
+	activePriorities.Store(hash, priority)
+}
+
+func clearActivePriority(hash string) {
+	activePriorities.Delete(hash)
+}
+
+// allocatedBpsDown returns the torrent's proportional share of the global
+// download cap in bytes per second. Zero means no cap applies.
+func allocatedBpsDown(hash string) int64 {
+	if maxDownloadBps == 0 {
+		return 0
+	}
+
+	var total, mine int64
+	activePriorities.Range(func(k, v interface{}) bool {
+		w := priorityWeight[v.(string)]
+		total += w
+		if k.(string) == hash {
+			mine = w
+		}
+		return true
+	})
+
+	if total == 0 || mine == 0 {
+		return 0
+	}
+
+	return maxDownloadBps * mine / total
+}
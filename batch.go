@@ -0,0 +1,77 @@
+package main
+
+// Batch operations run one action across many torrents server-side, so the
+// client doesn't loop a request per torrent and get back a storm of acks.
+
+// TorrentBatchPayload for MsgTorrentBatch. The torrents come from Hashes
+// when given, otherwise from Filter, which reuses the list request's filters
+// and ignores its paging and sorting fields.
+type TorrentBatchPayload struct {
+	Action string              `json:"action"`
+	Hashes []string            `json:"hashes,omitempty"`
+	Filter *TorrentListPayload `json:"filter,omitempty"`
+	// LabelID applies to the labelSet action, nil clears the label
+	LabelID *string `json:"labelID,omitempty"`
+	// WithData and Archive apply to the delete action
+	WithData bool `json:"withData,omitempty"`
+	Archive  bool `json:"archive,omitempty"`
+}
+
+// TorrentBatchResult summarizes a batch run, failures are keyed by hash
+type TorrentBatchResult struct {
+	Succeeded int               `json:"succeeded"`
+	Failed    map[string]string `json:"failed,omitempty"`
+}
+
+// runTorrentBatch applies the payload's action to every selected torrent and
+// keeps going past individual failures
+func runTorrentBatch(data *TorrentBatchPayload) (*TorrentBatchResult, error) {
+	hashes := data.Hashes
+	if len(hashes) == 0 && data.Filter != nil {
+		matched, err := filterTorrents(data.Filter)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range matched {
+			hashes = append(hashes, info.Hash)
+		}
+	}
+	if len(hashes) == 0 {
+		return nil, ErrBadRequest
+	}
+
+	result := &TorrentBatchResult{}
+	for _, hash := range hashes {
+		var err error
+		switch data.Action {
+		case "stop":
+			err = handleMsgTorrentStop(hash)
+		case "start":
+			err = startStoppedTorrent(hash)
+		case "delete":
+			err = handleMsgTorrentDelete(&TorrentDeletePayload{
+				Hash:     hash,
+				WithData: data.WithData,
+				Archive:  data.Archive,
+			})
+		case "labelSet":
+			err = handleMsgLabelSet(&LabelSetPayload{
+				Hash:    hash,
+				LabelID: data.LabelID,
+			})
+		default:
+			return nil, ErrBadRequest
+		}
+
+		if err != nil {
+			if result.Failed == nil {
+				result.Failed = make(map[string]string)
+			}
+			result.Failed[hash] = err.Error()
+		} else {
+			result.Succeeded++
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	socket "github.com/akillmer/go-socket"
+	"github.com/akillmer/riptide/queue"
+)
+
+// Transfer budget for metered seedbox/VPS plans. Usage is counted in memory
+// for the current session; a monthly period just zeroes the counters when the
+// month rolls over.
+var (
+	budgetDown   int64 // caps in bytes, 0 means unlimited
+	budgetUp     int64
+	budgetPeriod string
+
+	usedDownBytes int64
+	usedUpBytes   int64
+
+	budgetPaused   bool
+	pausedByBudget []string
+)
+
+// addBudgetUsage records bytes moved so the budget watcher can add them up
+func addBudgetUsage(down, up int64) {
+	if down > 0 {
+		atomic.AddInt64(&usedDownBytes, down)
+	}
+	if up > 0 {
+		atomic.AddInt64(&usedUpBytes, up)
+	}
+}
+
+func budgetExceeded() bool {
+	if budgetDown > 0 && atomic.LoadInt64(&usedDownBytes) >= budgetDown {
+		return true
+	}
+	if budgetUp > 0 && atomic.LoadInt64(&usedUpBytes) >= budgetUp {
+		return true
+	}
+	return false
+}
+
+// watchBudget pauses every running torrent once the transfer budget is spent
+// and resumes them when the period resets
+func watchBudget() {
+	if budgetDown == 0 && budgetUp == 0 {
+		return
+	}
+
+	period := time.Now().Month()
+	ticker := time.NewTicker(5 * time.Second)
+
+	for range ticker.C {
+		if budgetPeriod == "month" && time.Now().Month() != period {
+			period = time.Now().Month()
+			atomic.StoreInt64(&usedDownBytes, 0)
+			atomic.StoreInt64(&usedUpBytes, 0)
+
+			if budgetPaused {
+				budgetPaused = false
+				for _, hash := range pausedByBudget {
+					queue.ForceNext(hash)
+				}
+				pausedByBudget = nil
+				log.Printf("transfer budget reset, resuming torrents")
+				socket.Broadcast(MsgBudgetExceeded, false)
+			}
+		}
+
+		if !budgetPaused && budgetExceeded() {
+			budgetPaused = true
+			managedTorrents.Range(func(k, v interface{}) bool {
+				hash := k.(string)
+				pausedByBudget = append(pausedByBudget, hash)
+				stopTorrent(hash)
+				return true
+			})
+			log.Printf("transfer budget exceeded, pausing all torrents")
+			socket.Broadcast(MsgBudgetExceeded, true)
+		}
+	}
+}
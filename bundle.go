@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Export and import of curated torrent sets. A bundle carries the stored
+// records — magnet, label, added time, transfer counters — not the payload
+// data, so moving a collection between riptide boxes is a JSON file, with
+// each torrent arriving stopped and re-downloading or re-checking on its
+// own schedule.
+
+// bundleVersion guards the format, imports refuse bundles they don't speak
+const bundleVersion = 1
+
+// TorrentBundle is the export format, labels ride along so references in
+// the torrents resolve on the importing side
+type TorrentBundle struct {
+	Version    int            `json:"version"`
+	ExportedAt int64          `json:"exportedAt"`
+	Torrents   []*TorrentInfo `json:"torrents"`
+	Labels     []*Label       `json:"labels,omitempty"`
+}
+
+// TorrentExportPayload for MsgTorrentExport, no hashes means everything
+type TorrentExportPayload struct {
+	Hashes []string `json:"hashes,omitempty"`
+}
+
+// TorrentImportResult sums up what an import did
+type TorrentImportResult struct {
+	Added   []string `json:"added"`
+	Skipped []string `json:"skipped"`
+	Labels  int      `json:"labels"`
+}
+
+// decodeBundle decodes an import payload leniently. Exported records carry
+// derived read-only fields — the ISO timestamps TorrentInfo's MarshalJSON
+// adds — that aren't part of the stored shape, so running a bundle through
+// decodePayload's unknown-field rejection would refuse this server's own
+// exports. The payload shape limits still apply.
+func decodeBundle(payload interface{}) (*TorrentBundle, error) {
+	if err := checkPayloadShape(payload, "", 0); err != nil {
+		return nil, err
+	}
+
+	buf, err := json.Marshal(payload)
+	if err != nil {
+		return nil, ErrBadRequest
+	}
+
+	bundle := &TorrentBundle{}
+	if err := json.Unmarshal(buf, bundle); err != nil {
+		return nil, ErrBadRequest
+	}
+	return bundle, nil
+}
+
+// exportTorrents builds a bundle of the named torrents, or all of them
+func exportTorrents(hashes []string) (*TorrentBundle, error) {
+	bundle := &TorrentBundle{
+		Version:    bundleVersion,
+		ExportedAt: time.Now().Unix(),
+		Torrents:   []*TorrentInfo{},
+	}
+
+	if len(hashes) == 0 {
+		all, err := GetAllTorrentInfo()
+		if err != nil {
+			return nil, err
+		}
+		bundle.Torrents = append(bundle.Torrents, all...)
+	} else {
+		for _, hash := range hashes {
+			info, err := GetTorrentInfo(hash)
+			if err != nil {
+				return nil, fmt.Errorf("no torrent %s to export", hash)
+			}
+			bundle.Torrents = append(bundle.Torrents, info)
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, info := range bundle.Torrents {
+		if info.LabelID == "" || seen[info.LabelID] {
+			continue
+		}
+		seen[info.LabelID] = true
+		if label, err := GetLabel(info.LabelID); err == nil {
+			bundle.Labels = append(bundle.Labels, label)
+		}
+	}
+
+	return bundle, nil
+}
+
+// importTorrents replays a bundle into this instance. Torrents that already
+// exist are skipped, new ones land stopped so the user decides what this
+// box actually downloads. Missing labels are recreated with their original
+// IDs so the references hold.
+func importTorrents(bundle *TorrentBundle) (*TorrentImportResult, error) {
+	if bundle.Version != bundleVersion {
+		return nil, fmt.Errorf("can't import a version %d bundle", bundle.Version)
+	}
+
+	result := &TorrentImportResult{Added: []string{}, Skipped: []string{}}
+
+	for _, label := range bundle.Labels {
+		if label.ID == "" {
+			continue
+		}
+		if _, err := GetLabel(label.ID); err != nil {
+			if err := label.Save(); err != nil {
+				return nil, err
+			}
+			result.Labels++
+		}
+	}
+
+	for _, info := range bundle.Torrents {
+		if info.Hash == "" || info.Magnet == "" {
+			continue
+		}
+		if _, err := GetTorrentInfo(info.Hash); err == nil {
+			result.Skipped = append(result.Skipped, info.Hash)
+			continue
+		}
+
+		// the record describes another box's runtime, settle it here as a
+		// plain stopped torrent and let this instance earn its own state
+		info.Status = StatusStopped
+		info.Dir = ""
+		info.Error = ""
+		info.MetaState = ""
+		info.RetryCount = 0
+		info.NextRetry = 0
+		info.ForceStarted = false
+		info.PendingMove = ""
+		info.MoveWarning = ""
+		if _, err := GetLabel(info.LabelID); err != nil {
+			info.LabelID = ""
+		}
+
+		if err := info.SaveAndBroadcast(); err != nil {
+			return nil, err
+		}
+		appendHistory(info.Hash, "imported", "")
+		result.Added = append(result.Added, info.Hash)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import "sync"
+
+// Capability negotiation. The protocol version only moves for breaking
+// changes, but most of what's been added since v1 is new message types an
+// old frontend simply never sends. MsgClientInit advertises those along
+// with optional behaviors, so a client lights features up by what the
+// server actually supports instead of guessing from the version number,
+// and the handshake carries the client's own feature list back.
+
+// ServerCapabilities rides in InitClientData
+type ServerCapabilities struct {
+	Protocol int `json:"protocol"`
+	// Messages lists message types added after protocol 1 shipped, a
+	// client should hide UI for anything it wants that isn't listed
+	Messages []string `json:"messages"`
+	// Features names optional behaviors a client may opt into via its
+	// handshake, or that depend on how the server was started
+	Features []string `json:"features"`
+}
+
+// serverCapabilities describes this server for the init payload
+func serverCapabilities() *ServerCapabilities {
+	caps := &ServerCapabilities{
+		Protocol: ProtocolVersion,
+		Messages: []string{
+			MsgTorrentSearch,
+			MsgTorrentRetry,
+			MsgQueueRepair,
+			MsgQueueETA,
+			MsgTrackerReqs,
+			MsgTrackerReqSet,
+			MsgServerReconnect,
+			MsgTorrentPieceWindow,
+			MsgTorrentPeers,
+			MsgPeerCountries,
+			MsgOrphanScan,
+			MsgOrphanDelete,
+			MsgConfigCheck,
+			MsgLabelColorSuggest,
+		},
+		Features: []string{"gzipInit", "initChunks", "uploads"},
+	}
+
+	if len(geoRanges) > 0 {
+		caps.Features = append(caps.Features, "geoip")
+	}
+
+	return caps
+}
+
+// clientFeatures remembers what each connected client opted into through
+// its handshake, keyed by client ID
+var clientFeatures sync.Map
+
+// setClientFeatures records a client's handshake feature list
+func setClientFeatures(clientID string, features []string) {
+	if len(features) == 0 {
+		clientFeatures.Delete(clientID)
+		return
+	}
+
+	m := make(map[string]bool, len(features))
+	for _, f := range features {
+		m[f] = true
+	}
+	clientFeatures.Store(clientID, m)
+}
+
+// clientHasFeature reports whether the client opted into the named feature,
+// clients that never presented a list get none
+func clientHasFeature(clientID, feature string) bool {
+	if m, ok := clientFeatures.Load(clientID); ok {
+		return m.(map[string]bool)[feature]
+	}
+	return false
+}
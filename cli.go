@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	socket "github.com/akillmer/go-socket"
+	"github.com/gorilla/websocket"
+	"github.com/teris-io/shortid"
+)
+
+// The CLI mode drives a running riptide server over the same websocket API
+// the React app uses, so headless users don't need a browser at all. It's
+// entered from main() whenever the first argument is a subcommand rather
+// than a flag.
+
+const cliUsage = `usage: riptide <command> [arguments]
+
+commands:
+  add <magnet>              add a torrent by its magnet URI
+  list                      list stored torrents
+  stop <hash>               stop a torrent
+  label set <hash> [id]     set a torrent's label, omit the id to clear it
+
+The server address comes from RIPTIDE_ADDR, default localhost:6500.
+`
+
+func runCLI(args []string) {
+	addr := os.Getenv("RIPTIDE_ADDR")
+	if addr == "" {
+		addr = "localhost:6500"
+	}
+
+	conn, err := dialServer(addr)
+	if err != nil {
+		cliFatalf("failed to connect to %s: %v", addr, err)
+	}
+	defer conn.Close()
+
+	switch args[0] {
+	case "add":
+		if len(args) != 2 {
+			cliFatalf(cliUsage)
+		}
+		cliCheck(conn.request(MsgTorrentAdd, args[1]))
+		fmt.Println("added")
+
+	case "list":
+		reply := cliCheck(conn.request(MsgTorrentList, &TorrentListPayload{}))
+		page := &TorrentListPage{}
+		if err := decodePayload(reply.Payload, page); err != nil {
+			cliFatalf("unexpected reply payload: %v", err)
+		}
+		for _, info := range page.Torrents {
+			fmt.Printf("%s  %-8s  %s\n", info.Hash, info.Status, info.Name)
+		}
+		if page.Total > len(page.Torrents) {
+			fmt.Printf("(%d of %d torrents)\n", len(page.Torrents), page.Total)
+		}
+
+	case "stop":
+		if len(args) != 2 {
+			cliFatalf(cliUsage)
+		}
+		cliCheck(conn.request(MsgTorrentStop, args[1]))
+		fmt.Println("stopped")
+
+	case "label":
+		if len(args) < 3 || args[1] != "set" {
+			cliFatalf(cliUsage)
+		}
+		payload := &LabelSetPayload{Hash: args[2]}
+		if len(args) > 3 {
+			payload.LabelID = &args[3]
+		}
+		cliCheck(conn.request(MsgTorrentLabelSet, payload))
+		fmt.Println("label updated")
+
+	default:
+		cliFatalf(cliUsage)
+	}
+}
+
+// cliConn is one short-lived websocket session with the server
+type cliConn struct {
+	conn *websocket.Conn
+}
+
+func dialServer(addr string) (*cliConn, error) {
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/api", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// the server greets every new connection with MsgClientInit, drain it
+	msg := &socket.Message{}
+	if err := conn.ReadJSON(msg); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &cliConn{conn: conn}, nil
+}
+
+func (c *cliConn) Close() {
+	c.conn.Close()
+}
+
+// request sends one message and blocks for the reply that echoes its request
+// ID, skipping any broadcasts that arrive in between
+func (c *cliConn) request(msgType string, payload interface{}) (*socket.Message, error) {
+	id, err := shortid.Generate()
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &socket.Message{Type: msgType, RequestID: id, Payload: payload}
+	if err := c.conn.WriteJSON(msg); err != nil {
+		return nil, err
+	}
+
+	for {
+		reply := &socket.Message{}
+		if err := c.conn.ReadJSON(reply); err != nil {
+			return nil, err
+		}
+		if reply.RequestID == id {
+			return reply, nil
+		}
+	}
+}
+
+// cliCheck exits with the server's error message when the reply is an error
+func cliCheck(reply *socket.Message, err error) *socket.Message {
+	if err != nil {
+		cliFatalf("request failed: %v", err)
+	}
+	if reply.Type == MsgClientError {
+		if buf, err := json.Marshal(reply.Payload); err == nil {
+			cliFatalf("server error: %s", buf)
+		}
+		cliFatalf("server error")
+	}
+	return reply
+}
+
+func cliFatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
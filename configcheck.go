@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// Settings dry-run. A UI settings form posts its proposed values here and
+// gets back every problem at once — missing or read-only directories,
+// nonsense limits, a taken port — without anything being applied, so the
+// form can refuse to submit instead of half-applying and erroring.
+
+// servePortActive is the port the server is already bound to, proposing it
+// back is fine even though a listen probe on it would fail
+var servePortActive string
+
+// ConfigCheckPayload is a proposed configuration, every field optional so
+// a form can validate just what the user touched
+type ConfigCheckPayload struct {
+	ServerSettings
+	DownloadDir   *string `json:"downloadDir,omitempty"`
+	IncompleteDir *string `json:"incompleteDir,omitempty"`
+	Port          *string `json:"port,omitempty"`
+}
+
+// ConfigIssue names one field's problem in a form-friendly shape
+type ConfigIssue struct {
+	Field   string `json:"field"`
+	Problem string `json:"problem"`
+}
+
+// ConfigCheckResult is the MsgConfigCheck reply, OK when Issues is empty
+type ConfigCheckResult struct {
+	OK     bool          `json:"ok"`
+	Issues []ConfigIssue `json:"issues"`
+}
+
+// checkConfig validates the proposal without applying any of it
+func checkConfig(data *ConfigCheckPayload) *ConfigCheckResult {
+	result := &ConfigCheckResult{Issues: []ConfigIssue{}}
+	bad := func(field, format string, args ...interface{}) {
+		result.Issues = append(result.Issues, ConfigIssue{Field: field, Problem: fmt.Sprintf(format, args...)})
+	}
+
+	if data.DownloadDir != nil {
+		checkWritableDir("downloadDir", *data.DownloadDir, bad)
+	}
+	if data.IncompleteDir != nil && *data.IncompleteDir != "" {
+		checkWritableDir("incompleteDir", *data.IncompleteDir, bad)
+	}
+
+	if data.Port != nil && *data.Port != servePortActive {
+		if ln, err := net.Listen("tcp", ":"+*data.Port); err != nil {
+			bad("port", "can't listen on port %s: %v", *data.Port, err)
+		} else {
+			ln.Close()
+		}
+	}
+
+	if data.MaxActiveTorrents != nil && *data.MaxActiveTorrents < 1 {
+		bad("maxActiveTorrents", "needs at least one active slot")
+	}
+	if data.MaxSeedTorrents != nil && *data.MaxSeedTorrents < 0 {
+		bad("maxSeedTorrents", "can't be negative, 0 means unlimited")
+	}
+	if data.GlobalRatio != nil && *data.GlobalRatio < -1 {
+		bad("globalRatio", "must be -1 (unlimited), 0 (no seeding), or positive")
+	}
+	if data.SeedHours != nil && *data.SeedHours < 0 {
+		bad("seedHours", "can't be negative, 0 disables the cap")
+	}
+	if data.MaxDownloadSpeed != nil && *data.MaxDownloadSpeed < 0 {
+		bad("maxDownloadSpeed", "can't be negative, 0 means unlimited")
+	}
+	if data.MaxUploadSpeed != nil && *data.MaxUploadSpeed < 0 {
+		bad("maxUploadSpeed", "can't be negative, 0 means unlimited")
+	}
+
+	result.OK = len(result.Issues) == 0
+	return result
+}
+
+// checkWritableDir verifies the path is an existing directory this process
+// can create files in, probing with a real create rather than mode bits
+func checkWritableDir(field, dir string, bad func(field, format string, args ...interface{})) {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		bad(field, "%v", err)
+		return
+	}
+	if !fi.IsDir() {
+		bad(field, "%s is not a directory", dir)
+		return
+	}
+
+	probe, err := os.CreateTemp(dir, ".riptide-probe-")
+	if err != nil {
+		bad(field, "%s is not writable: %v", dir, err)
+		return
+	}
+	name := probe.Name()
+	probe.Close()
+	os.Remove(filepath.Clean(name))
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Supervisor integration. Under systemd (Type=notify) the server signals
+// readiness and answers watchdog pings, and -pidfile covers classic init
+// scripts. A Windows service wrapper would need golang.org/x/sys, which
+// isn't vendored — the tree is unix-only anyway (syscall.Statfs).
+
+// sdNotify sends one state message to the systemd notify socket, it's a
+// no-op when the server isn't running under systemd
+func sdNotify(state string) {
+	name := os.Getenv("NOTIFY_SOCKET")
+	if name == "" {
+		return
+	}
+	if name[0] == '@' {
+		// abstract socket namespace
+		name = "\x00" + name[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		log.Printf("failed to reach notify socket: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		log.Printf("failed to notify systemd: %v", err)
+	}
+}
+
+// watchdogPings answers systemd's watchdog at half the configured interval,
+// but only while the self-diagnostics pass, so a wedged server gets
+// restarted instead of pinging forever
+func watchdogPings() {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+
+	interval := time.Duration(usec) * time.Microsecond / 2
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		if checkHealth().OK {
+			sdNotify("WATCHDOG=1")
+		}
+	}
+}
+
+// writePIDFile for supervisors that track the server by PID
+func writePIDFile(path string) error {
+	return os.WriteFile(path, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644)
+}
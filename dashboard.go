@@ -0,0 +1,69 @@
+package main
+
+// LabelStats aggregates the torrents that share one label. An empty LabelID
+// covers torrents with no label.
+type LabelStats struct {
+	LabelID        string         `json:"labelID"`
+	CountByStatus  map[Status]int `json:"countByStatus"`
+	TotalBytes     int64          `json:"totalBytes"`
+	BytesCompleted int64          `json:"bytesCompleted"`
+	BytesUploaded  int64          `json:"bytesUploaded"`
+	BpsUp          int64          `json:"bpsUp"`
+	BpsDown        int64          `json:"bpsDown"`
+	Ratio          float64        `json:"ratio"`
+}
+
+// Dashboard is the aggregate view sent back for MsgDashboard so the frontend
+// doesn't have to sum over every torrent on each progress tick
+type Dashboard struct {
+	Global *LabelStats   `json:"global"`
+	Labels []*LabelStats `json:"labels"`
+}
+
+func (ls *LabelStats) add(info *TorrentInfo) {
+	ls.CountByStatus[info.Status]++
+	ls.TotalBytes += info.TotalBytes
+
+	if progress, ok := GetLatestProgress(info.Hash); ok {
+		ls.BytesCompleted += progress.BytesCompleted
+		ls.BytesUploaded += progress.BytesUploaded
+		ls.BpsUp += progress.BpsUp
+		ls.BpsDown += progress.BpsDown
+	} else if info.Status == StatusDone || info.Status == StatusSeeding {
+		ls.BytesCompleted += info.TotalBytes
+	}
+
+	if ls.BytesCompleted > 0 {
+		ls.Ratio = float64(ls.BytesUploaded) / float64(ls.BytesCompleted)
+	}
+}
+
+// buildDashboard aggregates every stored torrent into global and per-label stats
+func buildDashboard() (*Dashboard, error) {
+	dash := &Dashboard{
+		Global: &LabelStats{CountByStatus: make(map[Status]int)},
+	}
+
+	allInfo, err := GetAllTorrentInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	byLabel := make(map[string]*LabelStats)
+	for _, info := range allInfo {
+		dash.Global.add(info)
+
+		ls, ok := byLabel[info.LabelID]
+		if !ok {
+			ls = &LabelStats{
+				LabelID:       info.LabelID,
+				CountByStatus: make(map[Status]int),
+			}
+			byLabel[info.LabelID] = ls
+			dash.Labels = append(dash.Labels, ls)
+		}
+		ls.add(info)
+	}
+
+	return dash, nil
+}
@@ -4,6 +4,9 @@ import (
 	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"os"
+	"sync"
+	"time"
 
 	"github.com/anacrolix/torrent/metainfo"
 	"github.com/boltdb/bolt"
@@ -11,12 +14,37 @@ import (
 
 var (
 	db *bolt.DB
+	// mu lets Compact swap the database file out from under the accessors
+	// without racing an open transaction
+	mu sync.RWMutex
 	// BucketQueued key, holds Torrent hash keys by auto ID that are queued for activity
 	BucketQueued = []byte("Queued")
 	// BucketTorrents key, holds Torrents by hash key, contains static info and magnet URLs
 	BucketTorrents = []byte("Torrents")
 	// BucketLabels key, holds user created Labels by unique short id
 	BucketLabels = []byte("Labels")
+	// BucketArchive key, holds removed torrents by hash key that the user chose to keep around
+	BucketArchive = []byte("Archive")
+	// BucketHistory key, holds each torrent's recent activity events by hash key
+	BucketHistory = []byte("History")
+	// BucketActivity key, holds the server-wide activity feed
+	BucketActivity = []byte("Activity")
+	// BucketRules key, holds auto-assignment rules by unique short id
+	BucketRules = []byte("Rules")
+	// BucketSettings key, holds small server settings by name
+	BucketSettings = []byte("Settings")
+	// BucketCorrupt key, holds records quarantined at startup because they
+	// no longer decode, kept for manual repair
+	BucketCorrupt = []byte("Corrupt")
+	// BucketTransfer key, holds each torrent's hourly transfer rollups by hash
+	BucketTransfer = []byte("Transfer")
+	// BucketProfiles key, holds named settings profiles by unique short id
+	BucketProfiles = []byte("Profiles")
+	// BucketIntents key, holds write-ahead transition intents by torrent
+	// hash, cleared once a transition's writes have all landed
+	BucketIntents = []byte("Intents")
+	// BucketScripts key, holds user-written scripted rules by unique short id
+	BucketScripts = []byte("Scripts")
 	// ErrKeyNotValid if it's not metainfo.Hash, byte slice, string, struct pointer, GetFirstKey or GetLastKey
 	ErrKeyNotValid = errors.New("key does not satisfy interface requirements")
 	// ErrValueNotValid if it's not metainfo.Hash, byte slice, string, struct pointer, or AutoIncrement
@@ -34,10 +62,15 @@ const (
 	GetLastKey
 )
 
-// Open and initialize the database
+// Open and initialize the database. Bolt holds an exclusive lock on the
+// file, the short timeout turns a second instance's indefinite hang into an
+// immediate error.
 func Open(dbFile string) error {
-	boltdb, err := bolt.Open(dbFile, 0644, nil)
+	boltdb, err := bolt.Open(dbFile, 0644, &bolt.Options{Timeout: time.Second})
 	if err != nil {
+		if err == bolt.ErrTimeout {
+			return errors.New("database is locked by another riptide instance")
+		}
 		return err
 	}
 	db = boltdb
@@ -52,6 +85,36 @@ func Open(dbFile string) error {
 		if _, err := tx.CreateBucketIfNotExists(BucketLabels); err != nil {
 			return err
 		}
+		if _, err := tx.CreateBucketIfNotExists(BucketArchive); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(BucketHistory); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(BucketActivity); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(BucketRules); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(BucketSettings); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(BucketCorrupt); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(BucketTransfer); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(BucketProfiles); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(BucketIntents); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(BucketScripts); err != nil {
+			return err
+		}
 		return nil
 	})
 
@@ -123,6 +186,9 @@ func Put(bucket []byte, key, val interface{}) error {
 		return ErrValueNotValid
 	}
 
+	mu.RLock()
+	defer mu.RUnlock()
+
 	return db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket(bucket)
 		var k []byte
@@ -141,6 +207,9 @@ func Put(bucket []byte, key, val interface{}) error {
 // Get retrieves a value by the provided key interface. Key must be a type
 // of metainfo.Hash, byte slice, string, GetFirstKey, or GetLastKey.
 func Get(bucket []byte, key interface{}) ([]byte, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
 	var buf []byte
 
 	err := db.View(func(tx *bolt.Tx) error {
@@ -167,6 +236,9 @@ func Get(bucket []byte, key interface{}) ([]byte, error) {
 // All returns all stored objects as a slice within the provided bucket. If there
 // are no objects then nil is returned.
 func All(bucket []byte) [][]byte {
+	mu.RLock()
+	defer mu.RUnlock()
+
 	all := [][]byte{}
 
 	db.View(func(tx *bolt.Tx) error {
@@ -189,6 +261,9 @@ func All(bucket []byte) [][]byte {
 // Delete returns the object held by key within the provided bucket. Key must be a type
 // of metainfo.Hash, byte slice, string, GetFirstKey, or GetLastKey.
 func Delete(bucket []byte, key interface{}) error {
+	mu.RLock()
+	defer mu.RUnlock()
+
 	return db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket(bucket)
 		k, _, err := assertGetByKey(key, b)
@@ -199,12 +274,130 @@ func Delete(bucket []byte, key interface{}) error {
 	})
 }
 
+// Check verifies the database can still serve both reads and writes
+func Check() error {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	err := db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(BucketTorrents) == nil {
+			return errors.New("torrents bucket is missing")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return db.Update(func(tx *bolt.Tx) error {
+		return nil
+	})
+}
+
 // View provides a wrapper for the underlying Bolt DB
 func View(fn func(tx *bolt.Tx) error) error {
+	mu.RLock()
+	defer mu.RUnlock()
 	return db.View(fn)
 }
 
 // Update provides a wrapper for the underlying Bolt DB
 func Update(fn func(tx *bolt.Tx) error) error {
+	mu.RLock()
+	defer mu.RUnlock()
 	return db.Update(fn)
 }
+
+// BucketStats summarizes one bucket for the admin stats view
+type BucketStats struct {
+	Name  string `json:"name"`
+	Keys  int    `json:"keys"`
+	Bytes int64  `json:"bytes"`
+}
+
+// Stats reports each bucket's key count and stored byte size
+func Stats() ([]*BucketStats, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	stats := []*BucketStats{}
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			bs := &BucketStats{Name: string(name)}
+			b.ForEach(func(k, v []byte) error {
+				bs.Keys++
+				bs.Bytes += int64(len(k) + len(v))
+				return nil
+			})
+			stats = append(stats, bs)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// Compact copies every bucket into a fresh database file and swaps it into
+// place. Bolt files grow but never shrink on their own, so long-running
+// servers reclaim space this way. All other database access blocks for the
+// duration.
+func Compact() error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	path := db.Path()
+	tmpPath := path + ".compact"
+	os.Remove(tmpPath)
+
+	compacted, err := bolt.Open(tmpPath, 0644, nil)
+	if err != nil {
+		return err
+	}
+
+	err = db.View(func(src *bolt.Tx) error {
+		return compacted.Update(func(dst *bolt.Tx) error {
+			return src.ForEach(func(name []byte, b *bolt.Bucket) error {
+				dstBucket, err := dst.CreateBucket(name)
+				if err != nil {
+					return err
+				}
+				dstBucket.SetSequence(b.Sequence())
+				return b.ForEach(func(k, v []byte) error {
+					return dstBucket.Put(k, v)
+				})
+			})
+		})
+	})
+	if err != nil {
+		compacted.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := compacted.Close(); err != nil {
+		return err
+	}
+	if err := db.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		// the old file is gone from the handle but still on disk, reopen it
+		reopened, openErr := bolt.Open(path, 0644, nil)
+		if openErr != nil {
+			return openErr
+		}
+		db = reopened
+		return err
+	}
+
+	reopened, err := bolt.Open(path, 0644, nil)
+	if err != nil {
+		return err
+	}
+	db = reopened
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"hash/fnv"
+
+	socket "github.com/akillmer/go-socket"
+)
+
+// The API used to run every handler on one goroutine, so a slow one (a data
+// delete, a magnet that won't resolve) stalled every other client's commands.
+// Handlers now run on a small pool of workers. Messages are sharded onto a
+// worker by torrent hash, so commands aimed at the same torrent still run in
+// the order they arrived.
+
+// apiWorkers bounds how many handlers run at once
+const apiWorkers = 8
+
+var apiQueues []chan *socket.Message
+
+func startAPIWorkers() {
+	apiQueues = make([]chan *socket.Message, apiWorkers)
+	for i := range apiQueues {
+		ch := make(chan *socket.Message, 64)
+		apiQueues[i] = ch
+		go func(ch chan *socket.Message) {
+			for msg := range ch {
+				dispatchMessage(msg)
+			}
+		}(ch)
+	}
+}
+
+// ErrServerBusy when a message is turned away because its shard's backlog
+// is full
+var ErrServerBusy = errors.New("server busy, try again shortly")
+
+// submitMessage hands the message to its shard's worker. The enqueue never
+// blocks: this runs on the API read loop, and waiting on a full shard would
+// freeze every client's commands behind whatever wedged that shard. The
+// client gets a busy error and retries instead.
+func submitMessage(msg *socket.Message) {
+	h := fnv.New32a()
+	h.Write([]byte(messageKey(msg)))
+
+	select {
+	case apiQueues[h.Sum32()%apiWorkers] <- msg:
+	default:
+		sendError(msg.From, msg.RequestID, ErrServerBusy)
+	}
+}
+
+// messageKey picks the ordering domain for a message: the torrent hash when
+// the payload carries one, otherwise the sending client so one client's
+// other commands still run in order relative to each other
+func messageKey(msg *socket.Message) string {
+	switch p := msg.Payload.(type) {
+	case string:
+		// bare-string payloads are a hash, a label or rule id, or a magnet,
+		// each its own ordering domain
+		if p != "" {
+			return p
+		}
+	case map[string]interface{}:
+		if hash, ok := p["hash"].(string); ok && hash != "" {
+			return hash
+		}
+	}
+	return msg.From
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/akillmer/riptide/fs"
+	"golang.org/x/time/rate"
+)
+
+// HTTP download endpoints for completed torrents:
+//
+//	GET <baseurl>/download/{hash}            whole torrent, multi-file ones
+//	                                         stream as a ZIP built on the fly
+//	GET <baseurl>/download/{hash}/{index}    a single file, by its position in
+//	                                         the torrent's sorted file list
+//
+// The mount shares -files-auth with the WebDAV server and -http-rate caps
+// all download streams collectively.
+
+// httpRate caps browser downloads in KiB/s, shared across streams, 0 is
+// uncapped
+var httpRate int
+
+var httpRateLimiter *rate.Limiter
+
+// limitedWriter throttles writes against the shared download limiter
+type limitedWriter struct {
+	w io.Writer
+	r *http.Request
+}
+
+func (lw *limitedWriter) Write(b []byte) (n int, err error) {
+	for len(b) > 0 {
+		chunk := b
+		if burst := httpRateLimiter.Burst(); httpRateLimiter.Limit() != rate.Inf && len(chunk) > burst {
+			chunk = chunk[:burst]
+		}
+		if err = httpRateLimiter.WaitN(lw.r.Context(), len(chunk)); err != nil {
+			return
+		}
+		var wrote int
+		wrote, err = lw.w.Write(chunk)
+		n += wrote
+		if err != nil {
+			return
+		}
+		b = b[wrote:]
+	}
+	return
+}
+
+// mountDownloads registers the download handler at prefix
+func mountDownloads(prefix string) {
+	limit := rate.Inf
+	if httpRate > 0 {
+		limit = rate.Limit(httpRate << 10)
+	}
+	httpRateLimiter = rate.NewLimiter(limit, 256<<10)
+
+	http.HandleFunc(prefix+"/", func(w http.ResponseWriter, r *http.Request) {
+		if !checkFilesAuth(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="riptide"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handleDownload(w, r, strings.TrimPrefix(r.URL.Path, prefix+"/"))
+	})
+}
+
+func handleDownload(w http.ResponseWriter, r *http.Request, rest string) {
+	segs := strings.Split(strings.Trim(rest, "/"), "/")
+	if len(segs) == 0 || segs[0] == "" || len(segs) > 2 {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	info, err := GetTorrentInfo(segs[0])
+	if err != nil {
+		http.Error(w, "torrent not found", http.StatusNotFound)
+		return
+	}
+	if info.BytesCompleted < info.TotalBytes || !info.DataPresent() {
+		http.Error(w, "torrent is not complete", http.StatusConflict)
+		return
+	}
+
+	if len(segs) == 2 {
+		idx, err := strconv.Atoi(segs[1])
+		if err != nil || idx < 0 || idx >= len(info.Files) {
+			http.Error(w, "no such file", http.StatusNotFound)
+			return
+		}
+		serveTorrentFile(w, r, info, info.Files[idx])
+		return
+	}
+
+	fi, err := fs.Default.Stat(info.DataPath())
+	if err != nil {
+		http.Error(w, "data missing", http.StatusNotFound)
+		return
+	}
+	if !fi.IsDir() {
+		serveTorrentFile(w, r, info, "")
+		return
+	}
+
+	serveTorrentZip(w, r, info)
+}
+
+// serveTorrentFile streams one file from the torrent's payload. An empty
+// relative path means the payload is the file itself.
+func serveTorrentFile(w http.ResponseWriter, r *http.Request, info *TorrentInfo, rel string) {
+	name := diskRelPath(info, rel)
+	full := info.DataPath()
+	if name != "" {
+		full = path.Join(full, name)
+	}
+
+	f, err := os.Open(full)
+	if err != nil {
+		http.Error(w, "file missing", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		http.Error(w, "file missing", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(fi.Size(), 10))
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf("attachment; filename=%q", path.Base(full)))
+	if r.Method == "HEAD" {
+		return
+	}
+	io.Copy(&limitedWriter{w: w, r: r}, f)
+}
+
+// serveTorrentZip streams the torrent's folder as a ZIP built on the fly,
+// files go in uncompressed since torrent payloads rarely deflate
+func serveTorrentZip(w http.ResponseWriter, r *http.Request, info *TorrentInfo) {
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf("attachment; filename=%q", info.Name+".zip"))
+	if r.Method == "HEAD" {
+		return
+	}
+
+	base := info.DataPath()
+	zw := zip.NewWriter(&limitedWriter{w: w, r: r})
+	defer zw.Close()
+
+	fs.Default.Walk(base, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(base, p)
+		if err != nil {
+			return err
+		}
+
+		zf, err := zw.CreateHeader(&zip.FileHeader{
+			Name:   path.Join(info.Name, filepath.ToSlash(rel)),
+			Method: zip.Store,
+		})
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(zf, f)
+		return err
+	})
+}
+
+// diskRelPath maps a torrent file path to where it lives on disk, honoring
+// any renames recorded in FileNames
+func diskRelPath(info *TorrentInfo, rel string) string {
+	if renamed, ok := info.FileNames[rel]; ok {
+		return renamed
+	}
+	return rel
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"sync"
+
+	socket "github.com/akillmer/go-socket"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Per-file progress, computed from piece state so a finished episode shows
+// complete while the rest of a season pack is still coming down. It's a
+// one-off query by default, a client watching a details pane subscribes the
+// torrent and gets a broadcast with every manager tick instead.
+
+// FileProgress is one file's completion within a torrent
+type FileProgress struct {
+	Path           string  `json:"path"`
+	Length         int64   `json:"length"`
+	BytesCompleted int64   `json:"bytesCompleted"`
+	Percent        float64 `json:"percent"`
+}
+
+// TorrentFileProgress is the MsgTorrentFileProgress payload, both as a
+// reply and as the subscribed broadcast
+type TorrentFileProgress struct {
+	Hash  string          `json:"hash"`
+	Files []*FileProgress `json:"files"`
+}
+
+// FileSubPayload for MsgTorrentFileSub, Off drops the subscription
+type FileSubPayload struct {
+	Hash string `json:"hash"`
+	Off  bool   `json:"off,omitempty"`
+}
+
+// fileProgressSubs holds the hashes whose per-file progress broadcasts on
+// every tick
+var fileProgressSubs = sync.Map{}
+
+// fileProgressFor reads each file's completed bytes out of the live
+// torrent's piece states
+func fileProgressFor(hash string) (*TorrentFileProgress, error) {
+	t, ok := client.Torrent(metainfo.NewHashFromHex(hash))
+	if !ok || t.Info() == nil {
+		return nil, ErrTorrentNotRunning
+	}
+
+	files := t.Files()
+	result := &TorrentFileProgress{Hash: hash, Files: make([]*FileProgress, 0, len(files))}
+	for _, f := range files {
+		fp := &FileProgress{Path: f.DisplayPath(), Length: f.Length()}
+		for _, ps := range f.State() {
+			if ps.Complete {
+				fp.BytesCompleted += ps.Bytes
+			}
+		}
+		if fp.Length > 0 {
+			fp.Percent = float64(fp.BytesCompleted) / float64(fp.Length) * 100
+		}
+		result.Files = append(result.Files, fp)
+	}
+
+	return result, nil
+}
+
+// setFileProgressSub toggles a torrent's per-tick file progress broadcast
+func setFileProgressSub(hash string, on bool) {
+	if on {
+		fileProgressSubs.Store(hash, nil)
+	} else {
+		fileProgressSubs.Delete(hash)
+	}
+}
+
+// broadcastFileProgress pushes per-file progress for a subscribed torrent,
+// the manager calls it right after the regular progress broadcast
+func broadcastFileProgress(hash string) {
+	if _, ok := fileProgressSubs.Load(hash); !ok {
+		return
+	}
+	if result, err := fileProgressFor(hash); err == nil {
+		socket.Broadcast(MsgTorrentFileProg, result)
+	}
+}
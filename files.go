@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/webdav"
+)
+
+// Optional read-only WebDAV mount over the download directory, so completed
+// files can be fetched remotely (davfs, cadaver, most file managers) without
+// running a separate file server. The web UI itself has no login, so the
+// mount only answers read methods and can demand basic auth via -files-auth.
+
+var (
+	serveFiles bool
+	filesAuth  string
+)
+
+// readOnlyDAVMethods are the only methods the mount answers, everything that
+// writes or locks is rejected
+var readOnlyDAVMethods = map[string]bool{
+	"GET":      true,
+	"HEAD":     true,
+	"OPTIONS":  true,
+	"PROPFIND": true,
+}
+
+// mountFileServer registers the WebDAV handler at prefix, rooted at the
+// download directory
+func mountFileServer(prefix string) {
+	dav := &webdav.Handler{
+		Prefix:     prefix,
+		FileSystem: webdav.Dir(downloadDir),
+		LockSystem: webdav.NewMemLS(),
+	}
+
+	http.HandleFunc(prefix+"/", func(w http.ResponseWriter, r *http.Request) {
+		if !checkFilesAuth(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="riptide"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !readOnlyDAVMethods[r.Method] {
+			http.Error(w, "file access is read-only", http.StatusMethodNotAllowed)
+			return
+		}
+		dav.ServeHTTP(w, r)
+	})
+}
+
+// checkFilesAuth validates the request against -files-auth, an empty setting
+// leaves the mount open like the rest of the UI
+func checkFilesAuth(r *http.Request) bool {
+	if filesAuth == "" {
+		return true
+	}
+
+	want := strings.SplitN(filesAuth, ":", 2)
+	if len(want) != 2 {
+		return false
+	}
+
+	user, pass, ok := r.BasicAuth()
+	return ok &&
+		subtle.ConstantTimeCompare([]byte(user), []byte(want[0])) == 1 &&
+		subtle.ConstantTimeCompare([]byte(pass), []byte(want[1])) == 1
+}
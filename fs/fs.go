@@ -0,0 +1,82 @@
+// Package fs abstracts the handful of filesystem operations riptide performs
+// on torrent data, so alternative back ends (quota-aware network mounts, an
+// in-memory filesystem for tests) can be swapped in without touching the
+// move, delete, and symlink logic.
+package fs
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// FS is the filesystem the server's data management code runs against
+type FS interface {
+	Create(name string) (io.WriteCloser, error)
+	Stat(name string) (os.FileInfo, error)
+	MkdirAll(path string, perm os.FileMode) error
+	Rename(oldpath, newpath string) error
+	Symlink(oldname, newname string) error
+	Link(oldname, newname string) error
+	Remove(name string) error
+	RemoveAll(path string) error
+	Walk(root string, walkFn filepath.WalkFunc) error
+	// Usage reports used and free bytes on the filesystem holding path, a
+	// quota-aware back end can report its quota here instead
+	Usage(path string) (used, free uint64, err error)
+}
+
+// Default is the FS all data management goes through, the operating system's
+// filesystem unless replaced at startup
+var Default FS = osFS{}
+
+// osFS passes everything straight to the os and path/filepath packages
+type osFS struct{}
+
+func (osFS) Create(name string) (io.WriteCloser, error) {
+	return os.Create(name)
+}
+
+func (osFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFS) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (osFS) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func (osFS) Symlink(oldname, newname string) error {
+	return os.Symlink(oldname, newname)
+}
+
+func (osFS) Link(oldname, newname string) error {
+	return os.Link(oldname, newname)
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFS) RemoveAll(path string) error {
+	return os.RemoveAll(path)
+}
+
+func (osFS) Walk(root string, walkFn filepath.WalkFunc) error {
+	return filepath.Walk(root, walkFn)
+}
+
+func (osFS) Usage(path string) (used, free uint64, err error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, 0, err
+	}
+	bsize := uint64(stat.Bsize)
+	free = stat.Bavail * bsize
+	used = (stat.Blocks - stat.Bfree) * bsize
+	return used, free, nil
+}
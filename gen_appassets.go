@@ -0,0 +1,66 @@
+//go:build ignore
+
+// Generates appassets_gen.go from a built frontend directory, gzipping each
+// file into the appAssets map so the server ships as one binary. Run it
+// through go generate after building the app:
+//
+//	go run gen_appassets.go -dir ./app
+package main
+
+import (
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	dir := flag.String("dir", "./app", "built frontend directory to embed")
+	out := flag.String("out", "appassets_gen.go", "generated file to write")
+	flag.Parse()
+
+	var b strings.Builder
+	b.WriteString("// Code generated by gen_appassets.go. DO NOT EDIT.\n\n")
+	b.WriteString("package main\n\n")
+	b.WriteString("func init() {\n")
+	b.WriteString("\tregisterAppAssets(map[string][]byte{\n")
+
+	err := filepath.Walk(*dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		var packed strings.Builder
+		zw, _ := gzip.NewWriterLevel(&packed, gzip.BestCompression)
+		if _, err := zw.Write(data); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(*dir, path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&b, "\t\t%q: []byte(%q),\n", filepath.ToSlash(rel), packed.String())
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("failed to walk %s: %v", *dir, err)
+	}
+
+	b.WriteString("\t})\n}\n")
+
+	if err := os.WriteFile(*out, []byte(b.String()), 0644); err != nil {
+		log.Fatalf("failed to write %s: %v", *out, err)
+	}
+}
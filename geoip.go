@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Optional GeoIP enrichment. Point -geoip at a CSV of "network,country"
+// rows (CIDR and ISO 3166 alpha-2, the shape most GeoIP exports flatten to)
+// and the peers view carries a country code per peer, with MsgPeerCountries
+// aggregating the known swarms of every running torrent. Lookups hit a
+// sorted range table behind a cache keyed by address.
+
+// geoIPPath is the CSV the table loads from, empty disables enrichment
+var geoIPPath string
+
+type geoRange struct {
+	network *net.IPNet
+	country string
+}
+
+var (
+	geoRanges []geoRange
+	geoMu     sync.RWMutex
+	geoCache  = map[string]string{}
+)
+
+// PeerView is one swarm peer as the UI shows it
+type PeerView struct {
+	IP      string `json:"ip"`
+	Port    int    `json:"port"`
+	Country string `json:"country,omitempty"`
+}
+
+// loadGeoIP reads the range table, rows that don't parse (headers, IPv6
+// exports mixed with v4, comments) are skipped rather than fatal
+func loadGeoIP(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+
+	var ranges []geoRange
+	for {
+		row, err := reader.Read()
+		if err != nil {
+			break
+		}
+		if len(row) < 2 {
+			continue
+		}
+		_, network, err := net.ParseCIDR(strings.TrimSpace(row[0]))
+		if err != nil {
+			continue
+		}
+		cc := strings.ToUpper(strings.TrimSpace(row[1]))
+		if len(cc) != 2 {
+			continue
+		}
+		ranges = append(ranges, geoRange{network: network, country: cc})
+	}
+	if len(ranges) == 0 {
+		return fmt.Errorf("no usable rows in %s", path)
+	}
+
+	sort.Slice(ranges, func(i, j int) bool {
+		return bytes.Compare(ranges[i].network.IP.To16(), ranges[j].network.IP.To16()) < 0
+	})
+
+	geoMu.Lock()
+	geoRanges = ranges
+	geoCache = map[string]string{}
+	geoMu.Unlock()
+	return nil
+}
+
+// geoCountry resolves an address to its country code, "" when the table
+// isn't loaded or doesn't cover it
+func geoCountry(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	key := ip.String()
+
+	geoMu.RLock()
+	cc, hit := geoCache[key]
+	ranges := geoRanges
+	geoMu.RUnlock()
+	if hit {
+		return cc
+	}
+	if len(ranges) == 0 {
+		return ""
+	}
+
+	full := ip.To16()
+	idx := sort.Search(len(ranges), func(i int) bool {
+		return bytes.Compare(ranges[i].network.IP.To16(), full) > 0
+	})
+	if idx > 0 && ranges[idx-1].network.Contains(ip) {
+		cc = ranges[idx-1].country
+	}
+
+	geoMu.Lock()
+	geoCache[key] = cc
+	geoMu.Unlock()
+	return cc
+}
+
+// torrentPeers lists the known swarm of a running torrent with countries
+func torrentPeers(hash string) ([]*PeerView, error) {
+	t, ok := client.Torrent(metainfo.NewHashFromHex(hash))
+	if !ok {
+		return nil, ErrTorrentNotRunning
+	}
+
+	peers := []*PeerView{}
+	for _, p := range t.KnownSwarm() {
+		if p.IP == nil {
+			continue
+		}
+		peers = append(peers, &PeerView{
+			IP:      p.IP.String(),
+			Port:    p.Port,
+			Country: geoCountry(p.IP),
+		})
+	}
+	return peers, nil
+}
+
+// peerCountrySummary counts known swarm peers per country across every
+// running torrent, UNKNOWN gathers addresses the table doesn't cover
+func peerCountrySummary() map[string]int {
+	summary := map[string]int{}
+	managedTorrents.Range(func(k, v interface{}) bool {
+		t, ok := client.Torrent(metainfo.NewHashFromHex(k.(string)))
+		if !ok {
+			return true
+		}
+		for _, p := range t.KnownSwarm() {
+			if p.IP == nil {
+				continue
+			}
+			cc := geoCountry(p.IP)
+			if cc == "" {
+				cc = "UNKNOWN"
+			}
+			summary[cc]++
+		}
+		return true
+	})
+	return summary
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	socket "github.com/akillmer/go-socket"
+	db "github.com/akillmer/riptide/database"
+	"github.com/akillmer/riptide/queue"
+)
+
+// Global pause watcher for metered connections. The operator points riptide
+// at a condition — a sentinel file, a URL that answers with a flag, or a
+// network interface that only exists while tethering — and every torrent
+// pauses while the condition holds, resuming on its own when it clears.
+var (
+	pauseFile  string // pause while this file exists
+	pauseURL   string // pause while this URL answers truthy
+	pauseIface string // pause while this network interface exists
+
+	pausedByWatcher []string
+)
+
+const globalPauseKey = "globalPause"
+
+// GlobalPause is persisted so the UI can show why everything stopped, even
+// across a server restart
+type GlobalPause struct {
+	Paused bool      `json:"paused"`
+	Reason string    `json:"reason,omitempty"`
+	Since  time.Time `json:"since,omitempty"`
+}
+
+func getGlobalPause() *GlobalPause {
+	gp := &GlobalPause{}
+	if buf, err := db.Get(db.BucketSettings, globalPauseKey); err == nil && buf != nil {
+		json.Unmarshal(buf, gp)
+	}
+	return gp
+}
+
+// watchPauseConditions polls the configured conditions, engaging the global
+// pause when one holds and releasing it when all clear
+func watchPauseConditions() {
+	if pauseFile == "" && pauseURL == "" && pauseIface == "" {
+		return
+	}
+
+	// a pause left behind by a previous run clears once conditions allow,
+	// but don't leave the stale reason up if they already have
+	if gp := getGlobalPause(); gp.Paused && pauseReason() == "" {
+		releaseGlobalPause()
+	}
+
+	ticker := time.NewTicker(15 * time.Second)
+	for range ticker.C {
+		reason := pauseReason()
+		gp := getGlobalPause()
+
+		if reason != "" && !gp.Paused {
+			engageGlobalPause(reason)
+		} else if reason == "" && gp.Paused {
+			releaseGlobalPause()
+		}
+	}
+}
+
+// pauseReason reports why the server should pause, or "" when it shouldn't
+func pauseReason() string {
+	if pauseFile != "" {
+		if _, err := os.Stat(pauseFile); err == nil {
+			return "pause file " + pauseFile + " exists"
+		}
+	}
+	if pauseIface != "" {
+		if _, err := net.InterfaceByName(pauseIface); err == nil {
+			return "network interface " + pauseIface + " is up"
+		}
+	}
+	if pauseURL != "" && pauseURLFlagged() {
+		return "pause flag set at " + pauseURL
+	}
+	return ""
+}
+
+func pauseURLFlagged() bool {
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(pauseURL)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+
+	buf, err := io.ReadAll(io.LimitReader(resp.Body, 64))
+	if err != nil {
+		return false
+	}
+
+	switch strings.ToLower(strings.TrimSpace(string(buf))) {
+	case "1", "true", "pause", "yes":
+		return true
+	}
+	return false
+}
+
+func engageGlobalPause(reason string) {
+	managedTorrents.Range(func(k, v interface{}) bool {
+		hash := k.(string)
+		pausedByWatcher = append(pausedByWatcher, hash)
+		stopTorrent(hash)
+		return true
+	})
+
+	gp := &GlobalPause{Paused: true, Reason: reason, Since: time.Now()}
+	if err := db.Put(db.BucketSettings, globalPauseKey, gp); err != nil {
+		log.Printf("failed to persist global pause: %v", err)
+	}
+	log.Printf("global pause engaged: %s", reason)
+	socket.Broadcast(MsgGlobalPause, gp)
+}
+
+func releaseGlobalPause() {
+	for _, hash := range pausedByWatcher {
+		queue.ForceNext(hash)
+	}
+	pausedByWatcher = nil
+
+	gp := &GlobalPause{}
+	if err := db.Put(db.BucketSettings, globalPauseKey, gp); err != nil {
+		log.Printf("failed to persist global pause: %v", err)
+	}
+	log.Printf("global pause released, resuming torrents")
+	socket.Broadcast(MsgGlobalPause, gp)
+}
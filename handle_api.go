@@ -1,87 +1,659 @@
 package main
 
 import (
-	"encoding/json"
+	"bytes"
 	"errors"
 	"log"
-	"os"
-	"path"
 
 	socket "github.com/akillmer/go-socket"
 	db "github.com/akillmer/riptide/database"
 	queue "github.com/akillmer/riptide/queue"
+	"github.com/anacrolix/torrent/metainfo"
 )
 
 // Message types for communicating with the client app
 const (
-	MsgClientInit      = "CLIENT_INIT"
-	MsgClientError     = "CLIENT_ERROR"
-	MsgTorrentAdd      = "TORRENT_ADD"
-	MsgTorrentStop     = "TORRENT_STOP"
-	MsgTorrentInfo     = "TORRENT_INFO"
-	MsgTorrentProgress = "TORRENT_PROGRESS"
-	MsgTorrentForce    = "TORRENT_FORCE"
-	MsgTorrentDelete   = "TORRENT_DELETE"
-	MsgTorrentLabelSet = "TORRENT_LABEL_SET"
-	MsgLabelUpdate     = "LABEL_UPDATE"
-	MsgLabelDelete     = "LABEL_DELETE"
+	MsgClientInit         = "CLIENT_INIT"
+	MsgClientInitPartial  = "CLIENT_INIT_PARTIAL"
+	MsgClientError        = "CLIENT_ERROR"
+	MsgClientOK           = "CLIENT_OK"
+	MsgTorrentAdd         = "TORRENT_ADD"
+	MsgTorrentStop        = "TORRENT_STOP"
+	MsgTorrentInfo        = "TORRENT_INFO"
+	MsgTorrentProgress    = "TORRENT_PROGRESS"
+	MsgTorrentForce       = "TORRENT_FORCE"
+	MsgTorrentForceStart  = "TORRENT_FORCE_START"
+	MsgTorrentDelete      = "TORRENT_DELETE"
+	MsgTorrentLabelSet    = "TORRENT_LABEL_SET"
+	MsgTorrentRename      = "TORRENT_RENAME"
+	MsgTorrentRedownload  = "TORRENT_REDOWNLOAD"
+	MsgTorrentPriority    = "TORRENT_PRIORITY"
+	MsgTorrentFileRename  = "TORRENT_FILE_RENAME"
+	MsgLabelUpdate        = "LABEL_UPDATE"
+	MsgLabelColorSuggest  = "LABEL_COLOR_SUGGEST"
+	MsgLabelDelete        = "LABEL_DELETE"
+	MsgRuleUpdate         = "RULE_UPDATE"
+	MsgRuleDelete         = "RULE_DELETE"
+	MsgRuleList           = "RULE_LIST"
+	MsgScriptUpdate       = "SCRIPT_UPDATE"
+	MsgScriptDelete       = "SCRIPT_DELETE"
+	MsgScriptList         = "SCRIPT_LIST"
+	MsgArchiveList        = "ARCHIVE_LIST"
+	MsgDashboard          = "DASHBOARD"
+	MsgDiagnostics        = "DIAGNOSTICS"
+	MsgBudgetExceeded     = "BUDGET_EXCEEDED"
+	MsgClientStatus       = "CLIENT_STATUS"
+	MsgJobProgress        = "JOB_PROGRESS"
+	MsgArchiveRestore     = "ARCHIVE_RESTORE"
+	MsgTorrentHistory     = "TORRENT_HISTORY"
+	MsgTorrentList        = "TORRENT_LIST"
+	MsgTorrentMoveRetry   = "TORRENT_MOVE_RETRY"
+	MsgTorrentBatch       = "TORRENT_BATCH"
+	MsgTorrentMetadata    = "TORRENT_METADATA"
+	MsgTorrentMagnetSet   = "TORRENT_MAGNET_SET"
+	MsgDBStats            = "DB_STATS"
+	MsgDBCompact          = "DB_COMPACT"
+	MsgTorrentErrors      = "TORRENT_ERRORS"
+	MsgTorrentTransfer    = "TORRENT_TRANSFER"
+	MsgServerVersion      = "SERVER_VERSION"
+	MsgServerUpgrade      = "SERVER_UPGRADE"
+	MsgCorruptRecords     = "CORRUPT_RECORDS"
+	MsgUIConfig           = "UI_CONFIG"
+	MsgUIConfigSet        = "UI_CONFIG_SET"
+	MsgPeerWhitelist      = "PEER_WHITELIST"
+	MsgPeerWhitelistSet   = "PEER_WHITELIST_SET"
+	MsgQueuePolicy        = "QUEUE_POLICY"
+	MsgQueuePolicySet     = "QUEUE_POLICY_SET"
+	MsgPortTest           = "PORT_TEST"
+	MsgGlobalPause        = "GLOBAL_PAUSE"
+	MsgTorrentsUpdated    = "TORRENTS_UPDATED"
+	MsgTorrentFilesSet    = "TORRENT_FILES_SET"
+	MsgSettings           = "SETTINGS"
+	MsgSettingsSet        = "SETTINGS_SET"
+	MsgProfiles           = "PROFILES"
+	MsgProfileUpdate      = "PROFILE_UPDATE"
+	MsgProfileDelete      = "PROFILE_DELETE"
+	MsgProfileActivate    = "PROFILE_ACTIVATE"
+	MsgProfileActive      = "PROFILE_ACTIVE"
+	MsgTorrentRetry       = "TORRENT_RETRY"
+	MsgQueueRepair        = "QUEUE_REPAIR"
+	MsgQueueETA           = "QUEUE_ETA"
+	MsgTrackerReqs        = "TRACKER_REQS"
+	MsgTrackerReqSet      = "TRACKER_REQ_SET"
+	MsgTorrentSearch      = "TORRENT_SEARCH"
+	MsgServerReconnect    = "SERVER_RECONNECT"
+	MsgTorrentPieceWindow = "TORRENT_PIECE_WINDOW"
+	MsgTorrentPeers       = "TORRENT_PEERS"
+	MsgPeerCountries      = "PEER_COUNTRIES"
+	MsgConfigCheck        = "CONFIG_CHECK"
+	MsgTorrentPieceOrder  = "TORRENT_PIECE_ORDER"
+	MsgTorrentFileProg    = "TORRENT_FILE_PROGRESS"
+	MsgTorrentFileSub     = "TORRENT_FILE_SUB"
+	MsgTorrentExport      = "TORRENT_EXPORT"
+	MsgTorrentImport      = "TORRENT_IMPORT"
+	MsgOrphanScan         = "ORPHAN_SCAN"
+	MsgOrphanDelete       = "ORPHAN_DELETE"
+	MsgActivity           = "ACTIVITY"
+	MsgActivityHistory    = "ACTIVITY_HISTORY"
 )
 
 // Common errors with the client's use of the API
 var (
 	ErrBadRequest      = errors.New("bad request")
 	ErrTorrentNotFound = errors.New("torrent not found")
+	ErrInvalidName     = errors.New("invalid name")
 )
 
-func sendError(toClient string, err error) {
+func sendError(toClient, requestID string, err error) {
 	log.Printf("%s: %s: %v", MsgClientError, toClient, err)
-	if err := socket.Send(toClient, MsgClientError, err.Error()); err != nil {
+
+	// conflicts and payload rejections go back as structured payloads,
+	// everything else as a string
+	var payload interface{} = err.Error()
+	if conflict, ok := err.(*ConflictError); ok {
+		payload = conflict
+	} else if bad, ok := err.(*PayloadError); ok {
+		payload = bad
+	}
+
+	if err := socket.Reply(toClient, MsgClientError, requestID, payload); err != nil {
 		log.Printf("failed to send client error: %v", err)
 	}
 }
 
+// sendOK acknowledges a successful mutating operation, echoing the request ID
+// so the client knows which action succeeded
+func sendOK(toClient, requestID, msgType string) {
+	if err := socket.Reply(toClient, MsgClientOK, requestID, msgType); err != nil {
+		log.Printf("failed to send client ack: %v", err)
+	}
+}
+
 func handleAPI() {
+	startAPIWorkers()
+
 	for {
 		msg := socket.Read()
-		switch msg.Type {
 
-		case MsgTorrentAdd:
-			if err := handleMsgTorrentAdd(msg.Payload); err != nil {
-				sendError(msg.From, err)
+		if msg.Type == MsgClientInit {
+			hs := &ClientHandshake{}
+			if err := decodePayload(msg.Payload, hs); err != nil {
+				sendError(msg.From, msg.RequestID, err)
+			} else if hs.Protocol != ProtocolVersion {
+				sendError(msg.From, msg.RequestID, ErrProtocolVersion)
+			} else if hs.Session == sessionID {
+				setClientFeatures(msg.From, hs.Features)
+				gz := hs.Gzip || clientHasFeature(msg.From, "gzipInit")
+				if err := sendInitDelta(msg.From, hs.Seq, gz); err != nil {
+					sendError(msg.From, msg.RequestID, err)
+				}
+			} else {
+				setClientFeatures(msg.From, hs.Features)
+			}
+			continue
+		}
+
+		submitMessage(msg)
+	}
+}
+
+// dispatchMessage runs one API message to completion, either replying with
+// the requested data or acknowledging the action
+func dispatchMessage(msg *socket.Message) {
+	var err error
+	switch msg.Type {
+
+	case MsgTorrentAdd:
+		err = handleMsgTorrentAdd(msg.Payload)
+
+	case MsgTorrentStop:
+		err = handleMsgTorrentStop(msg.Payload)
+
+	case MsgTorrentForce:
+		if hash, ok := msg.Payload.(string); ok {
+			queue.ForceNext(hash)
+		} else {
+			err = ErrBadRequest
+		}
+
+	case MsgTorrentForceStart:
+		err = handleMsgTorrentForceStart(msg.Payload)
+
+	case MsgTorrentDelete:
+		err = handleMsgTorrentDelete(msg.Payload)
+
+	case MsgTorrentRename:
+		err = handleMsgTorrentRename(msg.Payload)
+
+	case MsgTorrentPriority:
+		err = handleMsgTorrentPriority(msg.Payload)
+
+	case MsgTorrentFilesSet:
+		err = handleMsgTorrentFilesSet(msg.Payload)
+
+	case MsgTorrentPieceOrder:
+		err = handleMsgTorrentPieceOrder(msg.Payload)
+
+	case MsgTorrentPieceWindow:
+		data := &PieceWindowPayload{}
+		if err := decodePayload(msg.Payload, data); err != nil {
+			sendError(msg.From, msg.RequestID, err)
+		} else if result, windowErr := setPieceWindow(data); windowErr != nil {
+			sendError(msg.From, msg.RequestID, windowErr)
+		} else if err := socket.Reply(msg.From, MsgTorrentPieceWindow, msg.RequestID, result); err != nil {
+			log.Printf("failed to send piece window result: %v", err)
+		}
+		return
+
+	case MsgTorrentRetry:
+		if hash, ok := msg.Payload.(string); ok {
+			err = retryTorrent(hash)
+		} else {
+			err = ErrBadRequest
+		}
+
+	case MsgTorrentRedownload:
+		if hash, ok := msg.Payload.(string); ok {
+			err = redownloadTorrent(hash)
+		} else {
+			err = ErrBadRequest
+		}
+
+	case MsgTorrentFileRename:
+		err = handleMsgTorrentFileRename(msg.Payload)
+
+	case MsgTorrentMoveRetry:
+		if hash, ok := msg.Payload.(string); ok {
+			err = retryMoveNow(hash)
+		} else {
+			err = ErrBadRequest
+		}
+
+	case MsgTorrentLabelSet:
+		err = handleMsgLabelSet(msg.Payload)
+
+	case MsgLabelUpdate:
+		err = handleMsgLabelUpdate(msg.Payload)
+
+	case MsgLabelDelete:
+		err = handleMsgLabelDelete(msg.Payload)
+
+	case MsgLabelColorSuggest:
+		if err := socket.Reply(msg.From, MsgLabelColorSuggest, msg.RequestID, suggestLabelColor()); err != nil {
+			log.Printf("failed to send label color suggestion: %v", err)
+		}
+		return
+
+	case MsgRuleUpdate:
+		err = handleMsgRuleUpdate(msg.Payload)
+
+	case MsgRuleDelete:
+		if id, ok := msg.Payload.(string); ok {
+			if err = DeleteRule(id); err == nil {
+				err = socket.Broadcast(MsgRuleDelete, id)
+			}
+		} else {
+			err = ErrBadRequest
+		}
+
+	case MsgRuleList:
+		if rules, listErr := GetAllRules(); listErr != nil {
+			sendError(msg.From, msg.RequestID, listErr)
+		} else if err := socket.Reply(msg.From, MsgRuleList, msg.RequestID, rules); err != nil {
+			log.Printf("failed to send rule list: %v", err)
+		}
+		return
+
+	case MsgScriptUpdate:
+		err = handleMsgScriptUpdate(msg.Payload)
+
+	case MsgScriptDelete:
+		if id, ok := msg.Payload.(string); ok {
+			if err = DeleteScriptRule(id); err == nil {
+				err = socket.Broadcast(MsgScriptDelete, id)
+			}
+		} else {
+			err = ErrBadRequest
+		}
+
+	case MsgScriptList:
+		if rules, listErr := GetAllScriptRules(); listErr != nil {
+			sendError(msg.From, msg.RequestID, listErr)
+		} else if err := socket.Reply(msg.From, MsgScriptList, msg.RequestID, rules); err != nil {
+			log.Printf("failed to send script rule list: %v", err)
+		}
+		return
+
+	case MsgProfileUpdate:
+		err = handleMsgProfileUpdate(msg.Payload)
+
+	case MsgProfileDelete:
+		if id, ok := msg.Payload.(string); ok {
+			if err = db.Delete(db.BucketProfiles, id); err == nil {
+				err = socket.Broadcast(MsgProfileDelete, id)
+			}
+		} else {
+			err = ErrBadRequest
+		}
+
+	case MsgProfileActivate:
+		if id, ok := msg.Payload.(string); ok {
+			err = activateProfile(id)
+		} else {
+			err = ErrBadRequest
+		}
+
+	case MsgProfiles:
+		if profiles, listErr := GetAllProfiles(); listErr != nil {
+			sendError(msg.From, msg.RequestID, listErr)
+		} else if err := socket.Reply(msg.From, MsgProfiles, msg.RequestID, &ProfileList{
+			Profiles: profiles,
+			Active:   getActiveProfile(),
+		}); err != nil {
+			log.Printf("failed to send profile list: %v", err)
+		}
+		return
+
+	case MsgClientStatus:
+		// the torrent client's internal status text, for remote debugging
+		buf := &bytes.Buffer{}
+		client.WriteStatus(buf)
+		if err := socket.Reply(msg.From, MsgClientStatus, msg.RequestID, buf.String()); err != nil {
+			log.Printf("failed to send client status: %v", err)
+		}
+		return
+
+	case MsgDiagnostics:
+		if err := socket.Reply(msg.From, MsgDiagnostics, msg.RequestID, checkHealth()); err != nil {
+			log.Printf("failed to send diagnostics: %v", err)
+		}
+		return
+
+	case MsgDashboard:
+		if dash, dashErr := buildDashboard(); dashErr != nil {
+			sendError(msg.From, msg.RequestID, dashErr)
+		} else if err := socket.Reply(msg.From, MsgDashboard, msg.RequestID, dash); err != nil {
+			log.Printf("failed to send dashboard: %v", err)
+		}
+		return
+
+	case MsgArchiveList:
+		if all, listErr := GetAllArchivedTorrents(); listErr != nil {
+			sendError(msg.From, msg.RequestID, listErr)
+		} else if err := socket.Reply(msg.From, MsgArchiveList, msg.RequestID, all); err != nil {
+			log.Printf("failed to send archive list: %v", err)
+		}
+		return
+
+	case MsgTorrentList:
+		data := &TorrentListPayload{}
+		if err := decodePayload(msg.Payload, data); err != nil {
+			sendError(msg.From, msg.RequestID, err)
+		} else if page, listErr := listTorrents(data); listErr != nil {
+			sendError(msg.From, msg.RequestID, listErr)
+		} else if err := socket.Reply(msg.From, MsgTorrentList, msg.RequestID, page); err != nil {
+			log.Printf("failed to send torrent list: %v", err)
+		}
+		return
+
+	case MsgTorrentHistory:
+		if hash, ok := msg.Payload.(string); ok {
+			if err := socket.Reply(msg.From, MsgTorrentHistory, msg.RequestID, getHistory(hash)); err != nil {
+				log.Printf("failed to send torrent history: %v", err)
 			}
+		} else {
+			sendError(msg.From, msg.RequestID, ErrBadRequest)
+		}
+		return
+
+	case MsgTorrentMetadata:
+		if hash, ok := msg.Payload.(string); !ok {
+			sendError(msg.From, msg.RequestID, ErrBadRequest)
+		} else if meta, metaErr := getTorrentMetadata(hash); metaErr != nil {
+			sendError(msg.From, msg.RequestID, metaErr)
+		} else if err := socket.Reply(msg.From, MsgTorrentMetadata, msg.RequestID, meta); err != nil {
+			log.Printf("failed to send torrent metadata: %v", err)
+		}
+		return
+
+	case MsgTorrentMagnetSet:
+		err = handleMsgTorrentMagnetSet(msg.Payload)
+
+	case MsgTorrentBatch:
+		data := &TorrentBatchPayload{}
+		if err := decodePayload(msg.Payload, data); err != nil {
+			sendError(msg.From, msg.RequestID, err)
+		} else if result, batchErr := runTorrentBatch(data); batchErr != nil {
+			sendError(msg.From, msg.RequestID, batchErr)
+		} else if err := socket.Reply(msg.From, MsgTorrentBatch, msg.RequestID, result); err != nil {
+			log.Printf("failed to send batch result: %v", err)
+		}
+		return
+
+	case MsgServerVersion:
+		if err := socket.Reply(msg.From, MsgServerVersion, msg.RequestID, LongVersion()); err != nil {
+			log.Printf("failed to send server version: %v", err)
+		}
+		return
 
-		case MsgTorrentStop:
-			if err := handleMsgTorrentStop(msg.Payload); err != nil {
-				sendError(msg.From, err)
+	case MsgTorrentSearch:
+		if query, ok := msg.Payload.(string); ok {
+			result := &SearchResult{Torrents: []*TorrentInfo{}}
+			hashes := searchTorrents(query)
+			result.Total = len(hashes)
+			for _, hash := range hashes {
+				if len(result.Torrents) >= defaultPageSize {
+					break
+				}
+				if info, err := GetTorrentInfo(hash); err == nil {
+					result.Torrents = append(result.Torrents, info)
+				}
+			}
+			if err := socket.Reply(msg.From, MsgTorrentSearch, msg.RequestID, result); err != nil {
+				log.Printf("failed to send search result: %v", err)
 			}
+		} else {
+			sendError(msg.From, msg.RequestID, ErrBadRequest)
+		}
+		return
+
+	case MsgTrackerReqs:
+		if err := socket.Reply(msg.From, MsgTrackerReqs, msg.RequestID, getTrackerRequirements()); err != nil {
+			log.Printf("failed to send tracker requirements: %v", err)
+		}
+		return
+
+	case MsgTrackerReqSet:
+		data := &TrackerRequirement{}
+		if err = decodePayload(msg.Payload, data); err == nil {
+			err = setTrackerRequirement(data)
+		}
 
-		case MsgTorrentForce:
-			if hash, ok := msg.Payload.(string); ok {
-				queue.ForceNext(hash)
+	case MsgTorrentFileProg:
+		if hash, ok := msg.Payload.(string); ok {
+			if result, progErr := fileProgressFor(hash); progErr != nil {
+				sendError(msg.From, msg.RequestID, progErr)
+			} else if err := socket.Reply(msg.From, MsgTorrentFileProg, msg.RequestID, result); err != nil {
+				log.Printf("failed to send file progress: %v", err)
+			}
+		} else {
+			sendError(msg.From, msg.RequestID, ErrBadRequest)
+		}
+		return
+
+	case MsgTorrentFileSub:
+		data := &FileSubPayload{}
+		if err = decodePayload(msg.Payload, data); err == nil {
+			if data.Hash == "" {
+				err = ErrBadRequest
 			} else {
-				sendError(msg.From, ErrBadRequest)
+				setFileProgressSub(data.Hash, !data.Off)
 			}
+		}
+
+	case MsgTorrentExport:
+		data := &TorrentExportPayload{}
+		if err := decodePayload(msg.Payload, data); err != nil {
+			sendError(msg.From, msg.RequestID, err)
+		} else if bundle, exportErr := exportTorrents(data.Hashes); exportErr != nil {
+			sendError(msg.From, msg.RequestID, exportErr)
+		} else if err := socket.Reply(msg.From, MsgTorrentExport, msg.RequestID, bundle); err != nil {
+			log.Printf("failed to send torrent bundle: %v", err)
+		}
+		return
 
-		case MsgTorrentDelete:
-			if err := handleMsgTorrentDelete(msg.Payload); err != nil {
-				sendError(msg.From, err)
+	case MsgTorrentImport:
+		if bundle, err := decodeBundle(msg.Payload); err != nil {
+			sendError(msg.From, msg.RequestID, err)
+		} else if result, importErr := importTorrents(bundle); importErr != nil {
+			sendError(msg.From, msg.RequestID, importErr)
+		} else if err := socket.Reply(msg.From, MsgTorrentImport, msg.RequestID, result); err != nil {
+			log.Printf("failed to send import result: %v", err)
+		}
+		return
+
+	case MsgConfigCheck:
+		data := &ConfigCheckPayload{}
+		if err := decodePayload(msg.Payload, data); err == nil {
+			if err := socket.Reply(msg.From, MsgConfigCheck, msg.RequestID, checkConfig(data)); err != nil {
+				log.Printf("failed to send config check: %v", err)
 			}
+		} else {
+			sendError(msg.From, msg.RequestID, ErrBadRequest)
+		}
+		return
+
+	case MsgQueueETA:
+		if etas, etaErr := queueETAs(); etaErr != nil {
+			sendError(msg.From, msg.RequestID, etaErr)
+		} else if err := socket.Reply(msg.From, MsgQueueETA, msg.RequestID, etas); err != nil {
+			log.Printf("failed to send queue ETAs: %v", err)
+		}
+		return
+
+	case MsgQueueRepair:
+		if report, repairErr := repairQueue(); repairErr != nil {
+			sendError(msg.From, msg.RequestID, repairErr)
+		} else if err := socket.Reply(msg.From, MsgQueueRepair, msg.RequestID, report); err != nil {
+			log.Printf("failed to send queue repair report: %v", err)
+		}
+		return
+
+	case MsgSettings:
+		if err := socket.Reply(msg.From, MsgSettings, msg.RequestID, settingsReport()); err != nil {
+			log.Printf("failed to send settings: %v", err)
+		}
+		return
 
-		case MsgTorrentLabelSet:
-			if err := handleMsgLabelSet(msg.Payload); err != nil {
-				sendError(msg.From, err)
+	case MsgSettingsSet:
+		err = setServerSettings(msg.Payload)
+
+	case MsgGlobalPause:
+		if err := socket.Reply(msg.From, MsgGlobalPause, msg.RequestID, getGlobalPause()); err != nil {
+			log.Printf("failed to send global pause state: %v", err)
+		}
+		return
+
+	case MsgPortTest:
+		// the probe blocks on outside services, don't hold up the worker
+		go func() {
+			if err := socket.Broadcast(MsgPortTest, testListenPort()); err != nil {
+				log.Printf("failed to broadcast port test result: %v", err)
 			}
+		}()
+		return
+
+	case MsgServerUpgrade:
+		data := &UpgradePayload{}
+		if err = decodePayload(msg.Payload, data); err == nil {
+			err = upgradeServer(data)
+		}
+
+	case MsgTorrentErrors:
+		hash, _ := msg.Payload.(string) // empty means every torrent
+		if err := socket.Reply(msg.From, MsgTorrentErrors, msg.RequestID, getTorrentErrors(hash)); err != nil {
+			log.Printf("failed to send torrent errors: %v", err)
+		}
+		return
 
-		case MsgLabelUpdate:
-			if err := handleMsgLabelUpdate(msg.Payload); err != nil {
-				sendError(msg.From, err)
+	case MsgTorrentTransfer:
+		if hash, ok := msg.Payload.(string); ok {
+			if err := socket.Reply(msg.From, MsgTorrentTransfer, msg.RequestID, getTransferHistory(hash)); err != nil {
+				log.Printf("failed to send transfer history: %v", err)
 			}
+		} else {
+			sendError(msg.From, msg.RequestID, ErrBadRequest)
+		}
+		return
+
+	case MsgActivityHistory:
+		if err := socket.Reply(msg.From, MsgActivityHistory, msg.RequestID, getActivity()); err != nil {
+			log.Printf("failed to send activity history: %v", err)
+		}
+		return
+
+	case MsgDBStats:
+		if stats, statsErr := db.Stats(); statsErr != nil {
+			sendError(msg.From, msg.RequestID, statsErr)
+		} else if err := socket.Reply(msg.From, MsgDBStats, msg.RequestID, stats); err != nil {
+			log.Printf("failed to send database stats: %v", err)
+		}
+		return
 
-		case MsgLabelDelete:
-			if err := handleMsgLabelDelete(msg.Payload); err != nil {
-				sendError(msg.From, err)
+	case MsgTorrentPeers:
+		if hash, ok := msg.Payload.(string); ok {
+			if peers, peersErr := torrentPeers(hash); peersErr != nil {
+				sendError(msg.From, msg.RequestID, peersErr)
+			} else if err := socket.Reply(msg.From, MsgTorrentPeers, msg.RequestID, peers); err != nil {
+				log.Printf("failed to send peer list: %v", err)
 			}
+		} else {
+			sendError(msg.From, msg.RequestID, ErrBadRequest)
+		}
+		return
+
+	case MsgPeerCountries:
+		if err := socket.Reply(msg.From, MsgPeerCountries, msg.RequestID, peerCountrySummary()); err != nil {
+			log.Printf("failed to send peer countries: %v", err)
+		}
+		return
+
+	case MsgOrphanScan:
+		if report, scanErr := scanOrphans(); scanErr != nil {
+			sendError(msg.From, msg.RequestID, scanErr)
+		} else if err := socket.Reply(msg.From, MsgOrphanScan, msg.RequestID, report); err != nil {
+			log.Printf("failed to send orphan report: %v", err)
+		}
+		return
+
+	case MsgOrphanDelete:
+		data := &OrphanDeletePayload{}
+		if err = decodePayload(msg.Payload, data); err == nil {
+			err = deleteOrphans(data)
+		}
+
+	case MsgDBCompact:
+		err = db.Compact()
+
+	case MsgServerReconnect:
+		err = serverReconnect()
+
+	case MsgCorruptRecords:
+		if err := socket.Reply(msg.From, MsgCorruptRecords, msg.RequestID, getCorruptRecords()); err != nil {
+			log.Printf("failed to send corrupt records: %v", err)
+		}
+		return
+
+	case MsgQueuePolicy:
+		if err := socket.Reply(msg.From, MsgQueuePolicy, msg.RequestID, getQueuePolicy()); err != nil {
+			log.Printf("failed to send queue policy: %v", err)
+		}
+		return
+
+	case MsgUIConfig:
+		if err := socket.Reply(msg.From, MsgUIConfig, msg.RequestID, getUIConfig()); err != nil {
+			log.Printf("failed to send UI config: %v", err)
+		}
+		return
+
+	case MsgUIConfigSet:
+		err = setUIConfig(msg.Payload)
+
+	case MsgPeerWhitelist:
+		if err := socket.Reply(msg.From, MsgPeerWhitelist, msg.RequestID, getPeerWhitelist()); err != nil {
+			log.Printf("failed to send peer whitelist: %v", err)
+		}
+		return
+
+	case MsgPeerWhitelistSet:
+		cidrs := []string{}
+		if err = decodePayload(msg.Payload, &cidrs); err == nil {
+			err = setPeerWhitelist(cidrs)
+		}
+
+	case MsgQueuePolicySet:
+		if policy, ok := msg.Payload.(string); ok {
+			err = setQueuePolicy(policy)
+		} else {
+			err = ErrBadRequest
+		}
+
+	case MsgArchiveRestore:
+		if hash, ok := msg.Payload.(string); ok {
+			err = restoreArchivedTorrent(hash)
+		} else {
+			err = ErrBadRequest
 		}
+
+	default:
+		// unknown message types get no acknowledgement
+		return
+	}
+
+	if err != nil {
+		sendError(msg.From, msg.RequestID, err)
+	} else {
+		sendOK(msg.From, msg.RequestID, msg.Type)
 	}
 }
 
@@ -89,113 +661,337 @@ func handleMsgTorrentAdd(payload interface{}) error {
 	if uri, ok := payload.(string); ok {
 		return addTorrentByMagnet(uri)
 	}
-	return ErrBadRequest
+
+	data := &TorrentAddPayload{}
+	if err := decodePayload(payload, data); err != nil {
+		return err
+	}
+	if data.Magnet == "" && data.Torrent == "" {
+		return ErrBadRequest
+	}
+	if data.Priority != "" && !validPriority(data.Priority) {
+		return ErrBadRequest
+	}
+	// check the label before anything is persisted so a typo'd ID fails the
+	// whole add instead of leaving an unlabeled torrent behind
+	if data.LabelID != "" {
+		if _, err := GetLabel(data.LabelID); err != nil {
+			return err
+		}
+	}
+	return addTorrent(data)
+}
+
+// handleMsgTorrentForceStart flags a torrent to run outside the queue, or
+// puts it back under the limits when Off is set. The flag is persisted so a
+// restart brings the torrent straight back up.
+func handleMsgTorrentForceStart(payload interface{}) error {
+	data := &TorrentForceStartPayload{}
+	if err := decodePayload(payload, data); err != nil {
+		return err
+	}
+	if data.Hash == "" {
+		return ErrBadRequest
+	}
+
+	if data.Off {
+		// the torrent keeps running, it just competes for slots again from
+		// its next transition on
+		return UpdateTorrent(data.Hash, func(info *TorrentInfo) error {
+			info.ForceStarted = false
+			return nil
+		})
+	}
+
+	err := UpdateTorrent(data.Hash, func(info *TorrentInfo) error {
+		info.ForceStarted = true
+		switch info.Status {
+		case StatusStopped, StatusError:
+			info.Status = StatusQueued
+			info.Error = ""
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, running := managedTorrents.Load(data.Hash); !running {
+		queue.Remove(data.Hash)
+		go startTorrent(data.Hash)
+	}
+	return nil
 }
 
 func handleMsgTorrentStop(payload interface{}) error {
 	if hash, ok := payload.(string); ok {
 		stopTorrent(hash)
-		info := &TorrentInfo{}
-
-		if buf, err := db.Get(db.BucketTorrents, hash); buf == nil {
+		err := UpdateTorrent(hash, func(info *TorrentInfo) error {
+			info.Status = StatusStopped
+			info.ForceStarted = false
+			return nil
+		})
+		if err == db.ErrNoSuchKey {
 			return ErrTorrentNotFound
-		} else if err != nil {
-			return err
-		} else if err := json.Unmarshal(buf, info); err != nil {
-			return err
 		}
-
-		info.Status = StatusStopped
-		return info.SaveAndBroadcast()
+		if err == nil {
+			appendHistory(hash, "stopped", "")
+		}
+		return err
 	}
 	return ErrBadRequest
 }
 
 func handleMsgTorrentDelete(payload interface{}) error {
-	if data, ok := payload.(map[string]interface{}); ok {
-		var torrentName string
+	data := &TorrentDeletePayload{}
+	if err := decodePayload(payload, data); err != nil {
+		return err
+	}
+	if data.Hash == "" {
+		return ErrBadRequest
+	}
 
-		if hash, ok := data["hash"].(string); ok {
-			info, err := GetTorrentInfo(hash)
-			if err != nil {
-				return err
-			}
+	info, err := GetTorrentInfo(data.Hash)
+	if err != nil {
+		return err
+	}
 
-			torrentName = info.Name
-			stopTorrent(hash)
+	dataFolder := info.DataPath()
+	stopTorrent(data.Hash)
 
-			if err := db.Delete(db.BucketTorrents, hash); err != nil {
-				return err
-			}
-			if err := queue.Remove(hash); err != nil {
-				return err
-			}
-			if err := socket.Broadcast(MsgTorrentDelete, hash); err != nil {
-				return err
-			}
-		} else {
-			return ErrBadRequest
+	if data.Archive {
+		if err := archiveTorrent(info); err != nil {
+			return err
 		}
+	}
+
+	if err := db.Delete(db.BucketTorrents, data.Hash); err != nil {
+		return err
+	}
+	// the history goes with the torrent, archived entries keep what matters
+	db.Delete(db.BucketHistory, data.Hash)
+	db.Delete(db.BucketTransfer, data.Hash)
+	torrentErrors.Delete(data.Hash)
+	unindexTorrent(data.Hash)
+	appendActivity(data.Hash, info.Name, "removed", "")
+	if err := queue.Remove(data.Hash); err != nil {
+		return err
+	}
+	if err := socket.Broadcast(MsgTorrentDelete, data.Hash); err != nil {
+		return err
+	}
+
+	if data.WithData {
+		// never block the API loop on the disk, huge torrents take a while
+		deleteDataAsync(data.Hash, dataFolder)
+	}
+
+	return nil
+}
+
+func handleMsgTorrentMagnetSet(payload interface{}) error {
+	data := &TorrentMagnetSetPayload{}
+	if err := decodePayload(payload, data); err != nil {
+		return err
+	}
+	if data.Hash == "" || data.Magnet == "" {
+		return ErrBadRequest
+	}
+	return setTorrentMagnet(data.Hash, data.Magnet)
+}
+
+func handleMsgTorrentRename(payload interface{}) error {
+	data := &TorrentRenamePayload{}
+	if err := decodePayload(payload, data); err != nil {
+		return err
+	}
+	if data.Hash == "" {
+		return ErrBadRequest
+	}
+	return renameTorrent(data.Hash, data.Name)
+}
+
+func handleMsgTorrentFileRename(payload interface{}) error {
+	data := &TorrentFileRenamePayload{}
+	if err := decodePayload(payload, data); err != nil {
+		return err
+	}
+	if data.Hash == "" || data.Path == "" {
+		return ErrBadRequest
+	}
+	return renameTorrentFile(data.Hash, data.Path, data.Name)
+}
+
+func handleMsgTorrentPriority(payload interface{}) error {
+	data := &TorrentPriorityPayload{}
+	if err := decodePayload(payload, data); err != nil {
+		return err
+	}
+	if data.Hash == "" || !validPriority(data.Priority) {
+		return ErrBadRequest
+	}
+
+	err := UpdateTorrent(data.Hash, func(info *TorrentInfo) error {
+		info.Priority = data.Priority
+		return nil
+	})
+	if err != nil {
+		return err
+	}
 
-		if withData, ok := data["withData"].(bool); ok && withData && torrentName != "" {
-			dataFolder := path.Join(downloadDir, torrentName)
-			if err := os.RemoveAll(dataFolder); err != nil {
-				return err
+	// a running torrent picks up its new share right away
+	if _, ok := managedTorrents.Load(data.Hash); ok {
+		setActivePriority(data.Hash, data.Priority)
+	}
+
+	return nil
+}
+
+func handleMsgTorrentPieceOrder(payload interface{}) error {
+	data := &PieceOrderPayload{}
+	if err := decodePayload(payload, data); err != nil {
+		return err
+	}
+	if data.Hash == "" || !validPieceOrder(data.Order) {
+		return ErrBadRequest
+	}
+
+	// the manager re-applies the order on its next tick, no restart needed
+	return UpdateTorrent(data.Hash, func(info *TorrentInfo) error {
+		info.PieceOrder = data.Order
+		return nil
+	})
+}
+
+func handleMsgTorrentFilesSet(payload interface{}) error {
+	data := &TorrentFilesPayload{}
+	if err := decodePayload(payload, data); err != nil {
+		return err
+	}
+	if data.Hash == "" {
+		return ErrBadRequest
+	}
+
+	return UpdateTorrent(data.Hash, func(info *TorrentInfo) error {
+		// reject paths the torrent doesn't have so a stale client can't
+		// quietly deselect nothing
+		known := make(map[string]bool, len(info.Files))
+		for _, p := range info.Files {
+			known[p] = true
+		}
+		for _, p := range data.SkipFiles {
+			if !known[p] {
+				return ErrBadRequest
 			}
 		}
+		info.SkipFiles = data.SkipFiles
 
+		// a live torrent switches priorities in place, no stop/start cycle,
+		// and TotalBytes shrinks or grows to the wanted set so progress and
+		// the done check stay honest
+		if t, ok := client.Torrent(metainfo.NewHashFromHex(data.Hash)); ok && t.Info() != nil {
+			downloadWanted(t, info.SkipFiles)
+			info.TotalBytes = wantedBytes(t, info.SkipFiles)
+		}
 		return nil
-	}
-	return ErrBadRequest
+	})
 }
 
 func handleMsgLabelSet(payload interface{}) error {
-	if data, ok := payload.(map[string]interface{}); ok {
-		var hash, labelID string
-		if h, ok := data["hash"].(string); ok {
-			hash = h
-		} else {
-			return ErrBadRequest
-		}
+	data := &LabelSetPayload{}
+	if err := decodePayload(payload, data); err != nil {
+		return err
+	}
+	if data.Hash == "" {
+		return ErrBadRequest
+	}
 
-		if id, ok := data["labelID"].(string); ok {
-			labelID = id
-		} else {
+	err := UpdateTorrent(data.Hash, func(info *TorrentInfo) error {
+		if data.LabelID == nil {
 			// a `null` value of labelID means the torrent has no label now
-			info, err := GetTorrentInfo(hash)
-			if err != nil {
-				return err
-			}
 			info.LabelID = ""
-			return info.SaveAndBroadcast()
+			return nil
 		}
 
 		// a nil err implies the label exists
-		if _, err := GetLabel(labelID); err != nil {
+		if _, err := GetLabel(*data.LabelID); err != nil {
 			return err
 		}
 
-		info, err := GetTorrentInfo(hash)
-		if err != nil {
-			return err
-		}
-		info.LabelID = labelID
-		return info.SaveAndBroadcast()
+		info.LabelID = *data.LabelID
+		return nil
+	})
+	if err != nil {
+		return err
 	}
-	return ErrBadRequest
+
+	if data.LabelID == nil {
+		applyLabelLimits(data.Hash, "")
+	} else {
+		applyLabelLimits(data.Hash, *data.LabelID)
+	}
+	return nil
 }
 
 func handleMsgLabelUpdate(payload interface{}) error {
-	if data, ok := payload.(map[string]interface{}); ok {
-		if label, err := LabelFromPayload(data); err != nil {
-			return err
-		} else if err := label.Save(); err != nil {
-			return err
-		} else {
-			return socket.Broadcast(MsgLabelUpdate, label)
-		}
+	label := &Label{}
+	if err := decodePayload(payload, label); err != nil {
+		return err
 	}
-	return ErrBadRequest
+	if label.Name == "" {
+		return errors.New("label is missing name")
+	}
+
+	if err := label.Save(); err != nil {
+		return err
+	}
+	updateLabelLimits(label)
+	return socket.Broadcast(MsgLabelUpdate, label)
+}
+
+func handleMsgRuleUpdate(payload interface{}) error {
+	rule := &Rule{}
+	if err := decodePayload(payload, rule); err != nil {
+		return err
+	}
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+
+	if err := rule.Save(); err != nil {
+		return err
+	}
+	return socket.Broadcast(MsgRuleUpdate, rule)
+}
+
+func handleMsgScriptUpdate(payload interface{}) error {
+	rule := &ScriptRule{}
+	if err := decodePayload(payload, rule); err != nil {
+		return err
+	}
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+
+	if err := rule.Save(); err != nil {
+		return err
+	}
+	return socket.Broadcast(MsgScriptUpdate, rule)
+}
+
+func handleMsgProfileUpdate(payload interface{}) error {
+	p := &Profile{}
+	if err := decodePayload(payload, p); err != nil {
+		return err
+	}
+	if p.Name == "" {
+		return ErrInvalidName
+	}
+
+	if err := p.Save(); err != nil {
+		return err
+	}
+	return socket.Broadcast(MsgProfileUpdate, p)
 }
 
 func handleMsgLabelDelete(payload interface{}) error {
@@ -207,19 +1003,19 @@ func handleMsgLabelDelete(payload interface{}) error {
 		if err := socket.Broadcast(MsgLabelDelete, id); err != nil {
 			return err
 		}
+		dropLabelLimits(id)
 
-		allInfo, err := GetAllTorrentInfo()
+		// strip the label from its torrents and tell clients about all of
+		// them at once instead of one message per hash
+		hashes, err := ClearLabelFromTorrents(id)
 		if err != nil {
 			return err
 		}
-
-		for _, info := range allInfo {
-			if info.LabelID == id {
-				info.LabelID = ""
-				if err := info.SaveAndBroadcast(); err != nil {
-					return err
-				}
-			}
+		for _, hash := range hashes {
+			applyLabelLimits(hash, "")
+		}
+		if len(hashes) > 0 {
+			return socket.Broadcast(MsgTorrentsUpdated, hashes)
 		}
 
 		return nil
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"time"
+
+	db "github.com/akillmer/riptide/database"
+	"github.com/akillmer/riptide/fs"
+	"github.com/akillmer/riptide/queue"
+)
+
+// HealthStatus reports the state of each server component, served at /healthz
+// and over the socket as MsgDiagnostics
+type HealthStatus struct {
+	OK        bool   `json:"ok"`
+	Database  string `json:"database"`
+	Client    string `json:"client"`
+	DHTNodes  int    `json:"dhtNodes"`
+	FreeBytes uint64 `json:"freeBytes"`
+	Queue     string `json:"queue"`
+	// HeapBytes is the server's live heap, it approximates how much memory
+	// the client's caches and buffers are holding
+	HeapBytes uint64 `json:"heapBytes"`
+}
+
+// checkHealth runs the self-diagnostics against every component
+func checkHealth() *HealthStatus {
+	health := &HealthStatus{OK: true, Database: "ok", Client: "ok", Queue: "ok"}
+
+	if err := db.Check(); err != nil {
+		health.OK = false
+		health.Database = err.Error()
+	}
+
+	if client == nil {
+		health.OK = false
+		health.Client = "not running"
+	} else if dht := client.DHT(); dht != nil {
+		health.DHTNodes = dht.NumNodes()
+	}
+
+	if _, free, err := fs.Default.Usage(downloadDir); err == nil {
+		health.FreeBytes = free
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	health.HeapBytes = mem.HeapAlloc
+
+	if time.Since(queue.LastTick()) > 5*time.Second {
+		health.OK = false
+		health.Queue = "stalled"
+	}
+
+	return health
+}
+
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	health := checkHealth()
+
+	w.Header().Set("Content-Type", "application/json")
+	if !health.OK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	json.NewEncoder(w).Encode(health)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	db "github.com/akillmer/riptide/database"
+)
+
+// TorrentEvent is one entry in a torrent's activity history
+type TorrentEvent struct {
+	Time   int64  `json:"time"`
+	Event  string `json:"event"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// maxHistoryEvents keeps each torrent's log small, old entries fall off
+const maxHistoryEvents = 50
+
+// appendHistory records an event in the torrent's activity history
+func appendHistory(hash, event, detail string) {
+	events := getHistory(hash)
+	events = append(events, &TorrentEvent{
+		Time:   time.Now().Unix(),
+		Event:  event,
+		Detail: detail,
+	})
+
+	if len(events) > maxHistoryEvents {
+		events = events[len(events)-maxHistoryEvents:]
+	}
+
+	if err := db.Put(db.BucketHistory, hash, events); err != nil {
+		log.Printf("failed to save history for %s: %v", hash, err)
+	}
+
+	if feedEvents[event] {
+		name := ""
+		if info, err := GetTorrentInfo(hash); err == nil {
+			name = info.Name
+		}
+		appendActivity(hash, name, event, detail)
+	}
+}
+
+// getHistory returns the torrent's recorded events, oldest first
+func getHistory(hash string) []*TorrentEvent {
+	events := []*TorrentEvent{}
+	if buf, err := db.Get(db.BucketHistory, hash); err == nil {
+		if err := json.Unmarshal(buf, &events); err != nil {
+			log.Printf("failed to read history for %s: %v", hash, err)
+		}
+	}
+	return events
+}
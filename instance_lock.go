@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Two riptide instances writing the same payload files corrupt them, and the
+// bolt lock only guards the database, not a shared download directory. A
+// pid-stamped lock file in the download directory catches that case; -force
+// steals a lock whose owner is gone (or that the user knows is stale).
+
+const instanceLockName = ".riptide.lock"
+
+// acquireInstanceLock claims the download directory, failing when a live
+// riptide instance already holds it
+func acquireInstanceLock(force bool) error {
+	lockPath := filepath.Join(downloadDir, instanceLockName)
+
+	if buf, err := os.ReadFile(lockPath); err == nil {
+		pid, _ := strconv.Atoi(strings.TrimSpace(string(buf)))
+		if pid > 0 && pid != os.Getpid() && pidAlive(pid) && !force {
+			return fmt.Errorf("another riptide (pid %d) is using %s, stop it or pass -force", pid, downloadDir)
+		}
+	}
+
+	return os.WriteFile(lockPath, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
+
+// releaseInstanceLock removes the lock file on a clean shutdown, a crash
+// leaves it behind and pidAlive sorts that out next boot
+func releaseInstanceLock() {
+	os.Remove(filepath.Join(downloadDir, instanceLockName))
+}
+
+// pidAlive reports whether a process with the pid exists, signal 0 probes
+// without delivering anything
+func pidAlive(pid int) bool {
+	return syscall.Kill(pid, 0) == nil
+}
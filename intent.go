@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	db "github.com/akillmer/riptide/database"
+	"github.com/akillmer/riptide/queue"
+)
+
+// Write-ahead intents for status transitions. A transition touches two
+// stores in separate bolt transactions — the queue bucket and the torrent
+// record — and a crash between the two leaves them disagreeing, the record
+// saying ACTIVE while the queue has already let the torrent go. The manager
+// notes what it's about to do before the first write and clears the note
+// after the last one, so bootstrap can finish a half-applied transition
+// deterministically instead of guessing from the wreckage.
+
+// Intent ops, what a crashed transition was in the middle of
+const (
+	IntentStart    = "START"
+	IntentStop     = "STOP"
+	IntentComplete = "COMPLETE"
+)
+
+// TransitionIntent is one pending transition, keyed by torrent hash
+type TransitionIntent struct {
+	Hash string `json:"hash"`
+	Op   string `json:"op"`
+	Time int64  `json:"time"`
+}
+
+// beginIntent notes the transition about to run, durable before any of the
+// transition's own writes
+func beginIntent(hash, op string) {
+	intent := &TransitionIntent{Hash: hash, Op: op, Time: time.Now().Unix()}
+	if err := db.Put(db.BucketIntents, hash, intent); err != nil {
+		log.Printf("failed to write %s intent for %s: %v", op, hash, err)
+	}
+}
+
+// endIntent clears the note once every write of the transition has landed
+func endIntent(hash string) {
+	db.Delete(db.BucketIntents, hash)
+}
+
+// reconcileIntents replays the tail of transitions a crash cut short. It
+// runs from bootstrap before any status is acted on or queue slot handed
+// out, so the flipped statuses take their normal startup branch.
+func reconcileIntents() {
+	for _, buf := range db.All(db.BucketIntents) {
+		intent := &TransitionIntent{}
+		if err := json.Unmarshal(buf, intent); err != nil {
+			log.Printf("failed to decode transition intent: %v", err)
+			continue
+		}
+
+		info, err := GetTorrentInfo(intent.Hash)
+		if err != nil {
+			// the record is gone, make sure the queue lets go of it too
+			queue.Remove(intent.Hash)
+			endIntent(intent.Hash)
+			continue
+		}
+
+		switch intent.Op {
+		case IntentStart:
+			// activation died before the client ever held the torrent, put
+			// it back in line and let repairQueue restore its entry
+			if info.Status == StatusActive && !info.ForceStarted {
+				info.Status = StatusQueued
+				info.SaveAndBroadcast()
+			}
+
+		case IntentComplete:
+			// the queue already moved on but the record never heard, finish
+			// the transition; the move, if one was underway, re-runs through
+			// the pending-move retry rather than here
+			if info.Status == StatusActive || info.Status == StatusSeeding {
+				info.Status = StatusDone
+				if info.TimeFinished == 0 {
+					info.TimeFinished = time.Now().Unix()
+				}
+				info.SaveAndBroadcast()
+			}
+			queue.Remove(intent.Hash)
+
+		case IntentStop:
+			// only a running manager writes this, so ACTIVE or SEEDING here
+			// means the stop's own save was the write that got lost
+			if info.Status == StatusActive || info.Status == StatusSeeding {
+				info.Status = StatusStopped
+				info.SaveAndBroadcast()
+				queue.Remove(intent.Hash)
+			}
+		}
+
+		log.Printf("reconciled %s intent for %s", intent.Op, intent.Hash)
+		endIntent(intent.Hash)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"log"
+	"os"
+	"time"
+
+	socket "github.com/akillmer/go-socket"
+	"github.com/akillmer/riptide/fs"
+	"github.com/teris-io/shortid"
+)
+
+// Long-running filesystem work runs as a background job so the API loop
+// never blocks on the disk.
+
+// JobProgress is broadcast as MsgJobProgress while a background job runs
+type JobProgress struct {
+	ID           string `json:"id"`
+	Kind         string `json:"kind"`
+	Hash         string `json:"hash,omitempty"`
+	BytesRemoved int64  `json:"bytesRemoved"`
+	Done         bool   `json:"done"`
+	Error        string `json:"error,omitempty"`
+}
+
+func (job *JobProgress) broadcast() {
+	if err := socket.Broadcast(MsgJobProgress, job); err != nil {
+		log.Printf("failed to broadcast job progress: %v", err)
+	}
+}
+
+// deleteDataAsync removes a torrent's data folder in the background. Deleting
+// a few hundred GB can take a while, so progress goes out about once a second
+// and a final broadcast reports success or failure.
+func deleteDataAsync(hash, folder string) {
+	id, err := shortid.Generate()
+	if err != nil {
+		id = hash
+	}
+
+	go func() {
+		job := &JobProgress{ID: id, Kind: "delete", Hash: hash}
+		job.broadcast()
+
+		lastSent := time.Now()
+		err := fs.Default.Walk(folder, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if fi.IsDir() {
+				return nil
+			}
+			if err := fs.Default.Remove(path); err != nil {
+				return err
+			}
+
+			job.BytesRemoved += fi.Size()
+			if time.Since(lastSent) >= time.Second {
+				lastSent = time.Now()
+				job.broadcast()
+			}
+			return nil
+		})
+
+		// the walk only removed files, sweep up the empty folders
+		if err == nil {
+			err = fs.Default.RemoveAll(folder)
+		}
+
+		job.Done = true
+		if err != nil {
+			job.Error = err.Error()
+			log.Printf("failed to delete %s: %v", folder, err)
+		}
+		job.broadcast()
+	}()
+}
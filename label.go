@@ -5,16 +5,51 @@ import (
 	"errors"
 
 	db "github.com/akillmer/riptide/database"
+	"github.com/boltdb/bolt"
 	"github.com/teris-io/shortid"
 )
 
 // Label that can be assigned to a torrent
 type Label struct {
-	ID     string `json:"id"`
-	Name   string `json:"name"`
-	Color  string `json:"color"`
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Color string `json:"color"`
+	// Icon names a glyph from the UI's icon set, a short lowercase slug
+	Icon   string `json:"icon,omitempty"`
 	MoveTo string `json:"moveToPath"`
 	// `moveTo` gets dropped by react, guessing it's reserved?
+
+	// NoSeed means torrents with this label never enter StatusSeeding
+	NoSeed bool `json:"noSeed,omitempty"`
+	// SeedDays stops a torrent's seeding N days after it started, 0 leaves
+	// the global ratio in charge
+	SeedDays int `json:"seedDays,omitempty"`
+	// MinRatio makes the label a ratio group: no auto-removal or seeding
+	// stop rule may touch its torrents below this ratio, private trackers
+	// punish early leavers
+	MinRatio float64 `json:"minRatio,omitempty"`
+
+	// HardlinkMove mirrors completed data into MoveTo with hardlinks (plain
+	// copies across filesystems) instead of moving it, so the torrent keeps
+	// seeding from the download directory while the library copy stands on
+	// its own. Superseded by CompletionAction, kept for stored labels.
+	HardlinkMove bool `json:"hardlinkMove,omitempty"`
+
+	// CompletionAction picks what happens to completed data: MOVE, COPY,
+	// HARDLINK, or MOVE_SYMLINK. Empty keeps the legacy behavior, which is
+	// MOVE_SYMLINK unless an incomplete directory splits the data off.
+	CompletionAction string `json:"completionAction,omitempty"`
+
+	// Unpack extracts RAR/ZIP archives after a torrent completes, and
+	// UnpackCleanup removes the archives once nothing seeds them anymore
+	Unpack        bool `json:"unpack,omitempty"`
+	UnpackCleanup bool `json:"unpackCleanup,omitempty"`
+
+	// MaxDownloadSpeed and MaxUploadSpeed cap the label's torrents in KiB/s,
+	// shared across all of them, 0 is unlimited. The global caps still apply
+	// on top.
+	MaxDownloadSpeed int `json:"maxDownloadSpeed,omitempty"`
+	MaxUploadSpeed   int `json:"maxUploadSpeed,omitempty"`
 }
 
 // Errors
@@ -38,33 +73,19 @@ func GetLabel(id string) (*Label, error) {
 	return lbl, nil
 }
 
-// LabelFromPayload creates a new label from a socket message payload
-func LabelFromPayload(data map[string]interface{}) (*Label, error) {
-	label := &Label{}
-
-	if id, ok := data["id"].(string); ok {
-		label.ID = id
-	}
-
-	if name, ok := data["name"].(string); ok && name != "" {
-		label.Name = name
-	} else {
-		return nil, errors.New("label is missing name")
+// Save this Label with the database. If it's a new label then a new short id is assigned.
+func (lbl *Label) Save() error {
+	if lbl.CompletionAction != "" && !completionActions[lbl.CompletionAction] {
+		return errors.New("unknown completion action")
 	}
-
-	if color, ok := data["color"].(string); ok {
-		label.Color = color
+	color, err := normalizeLabelColor(lbl.Color)
+	if err != nil {
+		return err
 	}
-
-	if moveTo, ok := data["moveToPath"].(string); ok {
-		label.MoveTo = moveTo
+	lbl.Color = color
+	if err := validateLabelIcon(lbl.Icon); err != nil {
+		return err
 	}
-
-	return label, nil
-}
-
-// Save this Label with the database. If it's a new label then a new short id is assigned.
-func (lbl *Label) Save() error {
 	if lbl.ID == "" {
 		id, err := shortid.Generate()
 		if err != nil {
@@ -79,3 +100,47 @@ func (lbl *Label) Save() error {
 func DeleteLabel(id string) error {
 	return db.Delete(db.BucketLabels, id)
 }
+
+// ClearLabelFromTorrents strips the label from every torrent that wears it
+// and returns the hashes that changed. The wearers are collected in one read
+// transaction, but each clear goes through UpdateTorrent so it serializes
+// with the torrent's manager — rewriting records directly would race a
+// concurrent manager tick, which could save its own copy right after and
+// resurrect the deleted label. The sweep isn't atomic as a whole, a clear
+// that fails midway leaves the earlier torrents cleared.
+func ClearLabelFromTorrents(id string) ([]string, error) {
+	var wearers []string
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(db.BucketTorrents).ForEach(func(k, v []byte) error {
+			info := &TorrentInfo{}
+			if err := json.Unmarshal(v, info); err != nil {
+				// quarantine handles undecodable records at boot, skip here
+				return nil
+			}
+			if info.LabelID == id {
+				wearers = append(wearers, info.Hash)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var hashes []string
+	for _, hash := range wearers {
+		err := UpdateTorrent(hash, func(info *TorrentInfo) error {
+			info.LabelID = ""
+			return nil
+		})
+		if err == db.ErrNoSuchKey {
+			// deleted since the scan, nothing to clear
+			continue
+		}
+		if err != nil {
+			return hashes, err
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	db "github.com/akillmer/riptide/database"
+)
+
+// Label color and icon hygiene. Clients send whatever their pickers produce,
+// so colors normalize to lowercase #rrggbb here and icons are held to a slug
+// the UI's icon fonts can all name. MsgLabelColorSuggest hands a new label a
+// hue as far as possible from the colors already in use, so labels created
+// from different clients still read apart at a glance.
+
+// labelIconPattern is the slug an icon name must match, e.g. "tv-series"
+var labelIconPattern = regexp.MustCompile(`^[a-z0-9-]{1,32}$`)
+
+var hexColorPattern = regexp.MustCompile(`^#?([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// normalizeLabelColor turns any accepted hex form into lowercase #rrggbb,
+// an empty color stays empty (the UI falls back to its default)
+func normalizeLabelColor(color string) (string, error) {
+	if color == "" {
+		return "", nil
+	}
+	if !hexColorPattern.MatchString(color) {
+		return "", fmt.Errorf("invalid label color %q", color)
+	}
+
+	hex := strings.ToLower(strings.TrimPrefix(color, "#"))
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	return "#" + hex, nil
+}
+
+// validateLabelIcon accepts an empty icon or a short lowercase slug
+func validateLabelIcon(icon string) error {
+	if icon == "" || labelIconPattern.MatchString(icon) {
+		return nil
+	}
+	return fmt.Errorf("invalid label icon %q", icon)
+}
+
+// colorHue extracts the hue in degrees from a normalized #rrggbb color,
+// ok is false for grays where hue means nothing
+func colorHue(color string) (hue float64, ok bool) {
+	if len(color) != 7 {
+		return 0, false
+	}
+	r, err1 := strconv.ParseUint(color[1:3], 16, 8)
+	g, err2 := strconv.ParseUint(color[3:5], 16, 8)
+	b, err3 := strconv.ParseUint(color[5:7], 16, 8)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return 0, false
+	}
+
+	rf, gf, bf := float64(r)/255, float64(g)/255, float64(b)/255
+	max := math.Max(rf, math.Max(gf, bf))
+	min := math.Min(rf, math.Min(gf, bf))
+	if max == min {
+		return 0, false
+	}
+
+	d := max - min
+	switch max {
+	case rf:
+		hue = math.Mod((gf-bf)/d, 6)
+	case gf:
+		hue = (bf-rf)/d + 2
+	default:
+		hue = (rf-gf)/d + 4
+	}
+	hue *= 60
+	if hue < 0 {
+		hue += 360
+	}
+	return hue, true
+}
+
+// hueToHex renders a hue as #rrggbb at fixed saturation and lightness, the
+// values labels look good at against both UI themes
+func hueToHex(hue float64) string {
+	const s, l = 0.55, 0.5
+	c := (1 - math.Abs(2*l-1)) * s
+	x := c * (1 - math.Abs(math.Mod(hue/60, 2)-1))
+	m := l - c/2
+
+	var rf, gf, bf float64
+	switch {
+	case hue < 60:
+		rf, gf, bf = c, x, 0
+	case hue < 120:
+		rf, gf, bf = x, c, 0
+	case hue < 180:
+		rf, gf, bf = 0, c, x
+	case hue < 240:
+		rf, gf, bf = 0, x, c
+	case hue < 300:
+		rf, gf, bf = x, 0, c
+	default:
+		rf, gf, bf = c, 0, x
+	}
+
+	return fmt.Sprintf("#%02x%02x%02x",
+		int((rf+m)*255+0.5), int((gf+m)*255+0.5), int((bf+m)*255+0.5))
+}
+
+// suggestLabelColor picks the hue with the widest gap to every existing
+// label's color. With no colored labels yet it starts at a plain blue
+func suggestLabelColor() string {
+	var used []float64
+	for _, buf := range db.All(db.BucketLabels) {
+		label := &Label{}
+		if err := json.Unmarshal(buf, label); err != nil {
+			continue
+		}
+		if hue, ok := colorHue(label.Color); ok {
+			used = append(used, hue)
+		}
+	}
+
+	if len(used) == 0 {
+		return hueToHex(210)
+	}
+
+	best, bestGap := 0.0, -1.0
+	for h := 0; h < 360; h += 5 {
+		gap := math.MaxFloat64
+		for _, u := range used {
+			d := math.Abs(float64(h) - u)
+			if d > 180 {
+				d = 360 - d
+			}
+			if d < gap {
+				gap = d
+			}
+		}
+		if gap > bestGap {
+			best, bestGap = float64(h), gap
+		}
+	}
+	return hueToHex(best)
+}
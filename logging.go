@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// The progress loop runs every second per torrent, so one bad tracker or
+// peer can fill the log with the same line forever. logf collapses runs of
+// identical messages and the per-torrent counters stay queryable over the
+// API even after the log has moved on.
+
+type logDeduper struct {
+	mu      sync.Mutex
+	last    string
+	repeats int
+}
+
+var dedup = &logDeduper{}
+
+// logf logs like log.Printf but collapses consecutive identical messages
+// into a single "last message repeated N times" line
+func logf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+
+	dedup.mu.Lock()
+	defer dedup.mu.Unlock()
+
+	if msg == dedup.last {
+		dedup.repeats++
+		return
+	}
+
+	if dedup.repeats > 0 {
+		log.Printf("last message repeated %d times", dedup.repeats)
+	}
+	dedup.last = msg
+	dedup.repeats = 0
+	log.Print(msg)
+}
+
+// TorrentErrors counts a torrent's recent errors, served as MsgTorrentErrors
+type TorrentErrors struct {
+	Hash     string `json:"hash"`
+	Count    int    `json:"count"`
+	Last     string `json:"last"`
+	LastTime int64  `json:"lastTime"`
+}
+
+// torrentErrors holds a *TorrentErrors per hash, reset only by restart or
+// torrent deletion
+var torrentErrors = sync.Map{}
+
+// recordTorrentError counts an error against the torrent and logs it with
+// deduplication
+func recordTorrentError(hash, msg string) {
+	v, _ := torrentErrors.LoadOrStore(hash, &TorrentErrors{Hash: hash})
+	te := v.(*TorrentErrors)
+	te.Count++
+	te.Last = msg
+	te.LastTime = time.Now().Unix()
+
+	logf("%s: %s", hash, msg)
+	fireWebhook("torrent.error", hash, msg)
+}
+
+// getTorrentErrors returns the counters for one torrent, or every torrent's
+// when hash is empty
+func getTorrentErrors(hash string) []*TorrentErrors {
+	all := []*TorrentErrors{}
+	torrentErrors.Range(func(key, val interface{}) bool {
+		if hash == "" || key.(string) == hash {
+			all = append(all, val.(*TorrentErrors))
+		}
+		return true
+	})
+	return all
+}
@@ -1,11 +1,23 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
 	"encoding/json"
 	"flag"
 	"log"
+	"net"
 	"net/http"
+	"net/http/pprof"
+	"net/url"
 	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"golang.org/x/time/rate"
 
@@ -14,59 +26,296 @@ import (
 	"github.com/akillmer/riptide/queue"
 	"github.com/anacrolix/dht"
 	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/storage"
+	"github.com/teris-io/shortid"
 )
 
 var (
-	client      *torrent.Client
+	client      TorrentClient
 	globalRatio float64
+	// seedHours stops a torrent's seeding once its cumulative seed time
+	// reaches this many hours even below the global ratio, 0 disables it
+	seedHours   int
 	downloadDir string
+	// moveCollision is what moveDoneData does when its destination already
+	// exists: suffix a counter, use a per-torrent subdir, or skip the move
+	moveCollision string
+	// incompleteDir, when set, holds in-progress downloads which only get
+	// moved into downloadDir (or the label's MoveTo) once complete
+	incompleteDir string
+	// maxDownloadBps is the global download cap the bandwidth allocator
+	// divides between active torrents, 0 means uncapped
+	maxDownloadBps int64
+	// sessionID identifies this server run, a reconnecting client presents
+	// it to prove its cached state is still resumable
+	sessionID string
 )
 
-// InitClientData is sent to every client that connects
+// InitClientData is sent to every client that connects. Torrents holds only
+// the first page of the collection, the client pulls the rest on demand via
+// MsgTorrentList.
 type InitClientData struct {
-	Torrents []*TorrentInfo `json:"torrents"`
-	Labels   []*Label       `json:"labels"`
+	Protocol     int            `json:"protocol"`
+	Session      string         `json:"session"`
+	Seq          int64          `json:"seq"`
+	TorrentCount int            `json:"torrentCount"`
+	Torrents     []*TorrentInfo `json:"torrents"`
+	Labels       []*Label       `json:"labels"`
+	// Partial means the torrent list was too big for one frame and follows
+	// as MsgClientInitPartial chunks instead of in Torrents
+	Partial bool `json:"partial,omitempty"`
+	// ServerTime and Timezone anchor the client's relative-time rendering
+	// to the server's clock instead of its own
+	ServerTime string `json:"serverTime"`
+	Timezone   string `json:"timezone"`
+	// Capabilities advertises this server's optional messages and features
+	// so progressive clients enable only what's actually supported
+	Capabilities *ServerCapabilities `json:"capabilities,omitempty"`
 }
 
 func main() {
+	// a leading subcommand means CLI mode against a running server
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		runCLI(os.Args[1:])
+		return
+	}
+
 	var (
 		maxDownloadSpeed  int
 		maxUploadSpeed    int
 		maxActiveTorrents int
+		maxSeedTorrents   int
 		devmode           bool
+		forceStart        bool
 		servePort         string
 		appDir            string
+		baseURL           string
+		listenProto       string
+		torrentAddr       string
+		randomPort        bool
+		wsMaxKB           int
+		announceIP        string
+		enablePprof       bool
+		storageMode       string
+		pieceCompletion   string
+		stallMinutes      int
+		trackerProxy      string
+		userAgent         string
+		peerIDPrefix      string
+		peerWhitelist     string
+		verifyHours       int
+		connsPerTorrent   int
+		peersHighWater    int
+		peersLowWater     int
+		pidFile           string
 	)
 
 	flag.StringVar(&downloadDir, "downloads", "./downloads", "directory for downloading torrents")
-	flag.IntVar(&maxActiveTorrents, "max", 1, "maximum number of active torrents")
+	flag.StringVar(&incompleteDir, "incomplete", "", "optional directory for in-progress downloads, data is moved out on completion")
+	flag.IntVar(&maxActiveTorrents, "max", 1, "maximum number of active downloads")
+	flag.IntVar(&maxSeedTorrents, "max-seeds", 0, "maximum number of seeding torrents (0: unlimited)")
 	flag.Float64Var(&globalRatio, "ratio", 1.0, "global ratio for all torrents (0: no seeding, -1: unlimited)")
+	flag.IntVar(&seedHours, "seed-hours", 0, "stop seeding after this much cumulative seed time, whichever of ratio or time comes first (0: ratio only)")
 	flag.BoolVar(&devmode, "devmode", false, "development mode")
+	flag.BoolVar(&forceStart, "force", false, "start even if another instance appears to hold the download directory")
+	flag.BoolVar(&serveFiles, "files", false, "serve completed downloads over read-only WebDAV at <baseurl>/files/")
+	flag.StringVar(&filesAuth, "files-auth", "", "optional user:pass basic auth for the WebDAV and download mounts")
+	flag.BoolVar(&allowUpgrade, "allow-upgrade", false, "allow clients to upgrade the server binary in place, pair with -files-auth")
+	flag.IntVar(&httpRate, "http-rate", 0, "maximum speed for browser downloads in KB/s, shared across streams (0: unlimited)")
 	flag.IntVar(&maxDownloadSpeed, "dl", 0, "maximum download speed in KB/s")
 	flag.IntVar(&maxUploadSpeed, "ul", 0, "maximum upload speed in KB/s")
 	flag.StringVar(&servePort, "port", "6500", "listening port for riptide clients")
 	flag.StringVar(&appDir, "app", "./app", "directory for serving static react app")
+	flag.StringVar(&baseURL, "base-url", "/", "base URL path when served behind a reverse proxy")
+	flag.StringVar(&listenProto, "protocol", "tcp", "listen family for clients and peers: tcp (dual-stack), tcp4, or tcp6")
+	flag.StringVar(&torrentAddr, "torrent-addr", "", "bind address for the torrent client (HOST:PORT)")
+	flag.BoolVar(&randomPort, "port-random", false, "bind the torrent client to a random ephemeral port each start")
+	flag.StringVar(&announceIP, "announce-ip", "", "IP announced to the DHT, for hosts with multiple address families")
+	flag.Int64Var(&budgetDown, "budget-dl", 0, "download budget in MB before all torrents pause (0: unlimited)")
+	flag.Int64Var(&budgetUp, "budget-ul", 0, "upload budget in MB before all torrents pause (0: unlimited)")
+	flag.StringVar(&budgetPeriod, "budget-period", "session", "when the transfer budget resets: session or month")
+	flag.StringVar(&pauseFile, "pause-file", "", "pause all torrents while this file exists")
+	flag.StringVar(&pauseURL, "pause-url", "", "pause all torrents while this URL answers 1/true/pause")
+	flag.StringVar(&pauseIface, "pause-iface", "", "pause all torrents while this network interface is up (e.g. a tethering interface)")
+	flag.IntVar(&scrapeMins, "scrape-mins", 15, "minutes between tracker scrapes for swarm counts (0: disabled)")
+	flag.IntVar(&wsMaxKB, "ws-max-kb", 256, "largest inbound websocket message in KiB (0: unlimited)")
+	flag.BoolVar(&enablePprof, "pprof", false, "mount net/http/pprof handlers under /debug/pprof/")
+	flag.StringVar(&storageMode, "storage", "file", "torrent data storage backend: file, mmap, or boltdb")
+	flag.StringVar(&pieceCompletion, "piece-completion", "bolt", "piece completion tracking: bolt or memory")
+	flag.IntVar(&stallMinutes, "stall", 10, "minutes without payload data or peers before an active torrent is requeued (0: disabled)")
+	flag.IntVar(&tickSeconds, "tick", 1, "seconds between progress updates for active torrents, idle seeders tick slower")
+	flag.BoolVar(&noUDPTrackers, "no-udp-trackers", false, "strip udp:// trackers from added magnets")
+	flag.StringVar(&trackerProxy, "tracker-proxy", "", "HTTP proxy URL for tracker announces")
+	flag.BoolVar(&proxyOnly, "proxy-only", false, "refuse announces that can't use the tracker proxy (implies -no-udp-trackers)")
+	flag.StringVar(&userAgent, "user-agent", "", "custom User-Agent for HTTP tracker announces")
+	flag.StringVar(&peerIDPrefix, "peer-id-prefix", "", "peer ID prefix announced to the swarm, bep20 style (e.g. -GT0001-)")
+	flag.StringVar(&peerWhitelist, "peer-whitelist", "", "comma-separated CIDRs, when set only these peers are talked to")
+	flag.IntVar(&verifyHours, "verify-hours", 0, "hours between re-verifying one seeded torrent's data (0: disabled)")
+	flag.IntVar(&connsPerTorrent, "conns-per-torrent", 0, "peer connections held per torrent, lower saves memory (0: client default)")
+	flag.IntVar(&maxOpenFDs, "max-fds", 0, "hold new activations while open file descriptors exceed this (0: disabled)")
+	flag.StringVar(&moveCollision, "move-collision", "suffix", "when a completed move's destination exists: suffix, subdir, or skip")
+	flag.StringVar(&geoIPPath, "geoip", "", "CSV of network,country rows for peer GeoIP enrichment")
+	flag.BoolVar(&noMDNS, "no-mdns", false, "don't announce the web UI over mDNS/DNS-SD")
+	flag.StringVar(&snapshotDir, "snapshots", "./snapshots", "directory for shutdown state snapshots (empty: disabled)")
+	flag.IntVar(&maxMetaFetches, "meta-fetches", 4, "concurrent magnet metadata resolutions, the rest queue (0: unlimited)")
+	flag.IntVar(&maxMemMB, "max-mem", 0, "hold new activations while the heap exceeds this many MB (0: disabled)")
+	flag.IntVar(&peersHighWater, "peers-high", 0, "peer addresses cached per torrent before discarding (0: client default)")
+	flag.IntVar(&peersLowWater, "peers-low", 0, "peer address cache level that triggers another announce (0: client default)")
+	flag.StringVar(&pidFile, "pidfile", "", "write the server's PID to this file at startup")
+	flag.StringVar(&webhookURL, "webhook", "", "URL POSTed a JSON event on client connects and health changes")
+	flag.IntVar(&seedRotateMins, "seed-rotate", 0, "minutes between rotating idle seeders out for waiting ones (0: disabled)")
 	flag.Parse()
+	initMetaFetchSlots()
+	servePortActive = servePort
+
+	stallAfter = time.Duration(stallMinutes) * time.Minute
+	verifyInterval = time.Duration(verifyHours) * time.Hour
+
+	if proxyOnly {
+		if trackerProxy == "" {
+			log.Fatal("-proxy-only requires -tracker-proxy")
+		}
+		noUDPTrackers = true
+	}
+
+	budgetDown <<= 20
+	budgetUp <<= 20
+	if budgetPeriod != "session" && budgetPeriod != "month" {
+		log.Fatalf("invalid -budget-period %q, want session or month", budgetPeriod)
+	}
+
+	switch listenProto {
+	case "tcp", "tcp4", "tcp6":
+		break
+	default:
+		log.Fatalf("invalid -protocol %q, want tcp, tcp4, or tcp6", listenProto)
+	}
+
+	baseURL = "/" + strings.Trim(baseURL, "/")
 
 	if err := db.Open("./.riptide.bolt.db"); err != nil {
 		log.Fatalf("failed to open riptide.db: %v", err)
 	}
 
+	// settings stored by previous runs fill in whatever the command line
+	// didn't say, flags that were passed win and are stored for next time
+	loadServerSettings(&maxActiveTorrents, &maxSeedTorrents, &maxDownloadSpeed, &maxUploadSpeed)
+
+	if err := os.MkdirAll(downloadDir, 0755); err != nil {
+		log.Fatalf("failed to make download directory: %v", err)
+	}
+	if err := acquireInstanceLock(forceStart); err != nil {
+		log.Fatal(err)
+	}
+
+	if id, err := shortid.Generate(); err != nil {
+		log.Fatalf("failed to generate session id: %v", err)
+	} else {
+		sessionID = id
+	}
+
+	clientDataDir := downloadDir
+	if incompleteDir != "" {
+		clientDataDir = incompleteDir
+	}
+	if err := os.MkdirAll(clientDataDir, 0755); err != nil {
+		log.Fatalf("failed to make data directory: %v", err)
+	}
+
 	cfg := &torrent.Config{
-		DataDir: downloadDir,
+		DataDir: clientDataDir,
 		DHTConfig: dht.ServerConfig{
 			StartingNodes: dht.GlobalBootstrapAddrs,
 		},
-		//DefaultStorage: storage.NewMMap(downloadDir),
+	}
+
+	// mmap storage drastically reduces memory for large torrents on small
+	// boxes, boltdb keeps everything in a single database file
+	switch storageMode {
+	case "file", "mmap":
+		var completion storage.PieceCompletion
+		if pieceCompletion == "memory" {
+			completion = storage.NewMapPieceCompletion()
+		} else if pieceCompletion == "bolt" {
+			pc, err := storage.NewBoltPieceCompletion(clientDataDir)
+			if err != nil {
+				log.Fatalf("failed to open piece completion db: %v", err)
+			}
+			completion = pc
+		} else {
+			log.Fatalf("invalid -piece-completion %q, want bolt or memory", pieceCompletion)
+		}
+
+		if storageMode == "mmap" {
+			cfg.DefaultStorage = storage.NewMMapWithCompletion(clientDataDir, completion)
+		} else {
+			cfg.DefaultStorage = storage.NewFileWithCompletion(clientDataDir, completion)
+		}
+	case "boltdb":
+		cfg.DefaultStorage = storage.NewBoltDB(clientDataDir)
+	default:
+		log.Fatalf("invalid -storage %q, want file, mmap, or boltdb", storageMode)
 	}
 
 	if globalRatio == 0 {
 		cfg.Seed = false
 	}
 
+	// these are the client's memory knobs: fewer held connections and a
+	// smaller peer address cache keep small boxes (Raspberry Pi seedboxes)
+	// out of swap, at the cost of some throughput. mmap storage (-storage)
+	// covers the piece data side.
+	if connsPerTorrent > 0 {
+		cfg.EstablishedConnsPerTorrent = connsPerTorrent
+		cfg.HalfOpenConnsPerTorrent = connsPerTorrent
+	}
+	if peersHighWater > 0 {
+		cfg.TorrentPeersHighWater = peersHighWater
+	}
+	if peersLowWater > 0 {
+		cfg.TorrentPeersLowWater = peersLowWater
+	}
+
+	if randomPort {
+		torrentAddr = randomTorrentPort(torrentAddr)
+		log.Printf("torrent client binding to %s", torrentAddr)
+	}
+	if torrentAddr != "" {
+		cfg.ListenAddr = torrentAddr
+	}
+	cfg.DisableIPv6 = listenProto == "tcp4"
+
+	if trackerProxy != "" {
+		u, err := url.Parse(trackerProxy)
+		if err != nil {
+			log.Fatalf("invalid -tracker-proxy %q: %v", trackerProxy, err)
+		}
+		cfg.HTTP = &http.Client{
+			Timeout: torrent.DefaultHTTPClient.Timeout,
+			Transport: &http.Transport{
+				Proxy: http.ProxyURL(u),
+			},
+		}
+	}
+	if userAgent != "" {
+		cfg.HTTPUserAgent = userAgent
+	}
+	if peerIDPrefix != "" {
+		cfg.Bep20 = peerIDPrefix
+	}
+
+	if announceIP != "" {
+		ip := net.ParseIP(announceIP)
+		if ip == nil {
+			log.Fatalf("invalid -announce-ip %q", announceIP)
+		}
+		cfg.DHTConfig.PublicIP = ip
+	}
+
 	if maxDownloadSpeed > 0 {
 		limit := rate.Limit(maxDownloadSpeed << 10)
 		cfg.DownloadRateLimiter = rate.NewLimiter(limit, 32<<10)
+		maxDownloadBps = int64(maxDownloadSpeed) << 10
 	}
 
 	if maxUploadSpeed > 0 {
@@ -79,8 +328,16 @@ func main() {
 	} else {
 		client = c
 	}
+	loadPeerWhitelist(peerWhitelist)
+	if geoIPPath != "" {
+		if err := loadGeoIP(geoIPPath); err != nil {
+			log.Printf("failed to load geoip table: %v", err)
+		}
+	}
 
-	socket.OnOpen = initDataWithClient
+	socket.MaxMessageSize = int64(wsMaxKB) << 10
+	socket.OnOpen = clientOpened
+	socket.OnClose = clientClosed
 	socket.OnError = func(clientID string, err error) {
 		log.Printf("%s: %v", clientID, err)
 	}
@@ -92,20 +349,59 @@ func main() {
 
 		servePort = "9800"
 	} else {
-		http.Handle("/", http.FileServer(http.Dir(appDir)))
+		socket.CheckOrigin = checkOrigin
+		app := appHandler(appDir)
+		if baseURL == "/" {
+			http.Handle("/", app)
+		} else {
+			http.Handle(baseURL+"/", http.StripPrefix(baseURL, app))
+		}
 	}
 
 	bootstrapTorrents()
+	go runWebhooks()
+	fireWebhook("server.started", "", "")
 	go handleAPI()
+	go watchBudget()
+	go watchPauseConditions()
+	go watchScrapes()
+	go watchProfiles()
+	go watchRetries()
+	go watchVerify()
+	go watchSeedRotation()
+	queue.SetMaxSeeds(maxSeedTorrents)
+	queue.SetHold(resourceHoldActive)
 	go queue.Run(maxActiveTorrents)
+	go watchResources()
 	go func() {
 		for {
-			hash := queue.Next()
-			go startTorrent(hash)
+			go startTorrent(queue.Next())
 		}
 	}()
 
-	http.HandleFunc("/api", socket.Handler)
+	if enablePprof {
+		http.HandleFunc("/debug/pprof/", pprof.Index)
+		http.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		http.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		http.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		http.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		log.Println("pprof handlers mounted at /debug/pprof/")
+	}
+
+	if serveFiles {
+		davPrefix := strings.TrimSuffix(path.Join(baseURL, "files"), "/")
+		mountFileServer(davPrefix)
+		log.Printf("read-only WebDAV mounted at %s/", davPrefix)
+	}
+	mountDownloads(strings.TrimSuffix(path.Join(baseURL, "download"), "/"))
+	mountUploads(strings.TrimSuffix(path.Join(baseURL, "upload"), "/"))
+
+	go announceMDNS(servePort, baseURL)
+	http.HandleFunc(path.Join(baseURL, "healthz"), handleHealthz)
+	http.HandleFunc(path.Join(baseURL, "api"), func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("%s connected via %s://%s%s", remoteAddr(r), remoteProto(r), r.Host, r.URL.Path)
+		socket.Handler(w, r)
+	})
 	log.Printf("Now serving on http://localhost:%s", servePort)
 
 	os.Remove("riptide.log")
@@ -118,20 +414,92 @@ func main() {
 	log.Printf("Saving log to riptide.log")
 	log.SetOutput(f)
 
-	log.Fatal(http.ListenAndServe(":"+servePort, nil))
+	ln, err := net.Listen(listenProto, ":"+servePort)
+	if err != nil {
+		log.Fatalf("failed to listen on %s port %s: %v", listenProto, servePort, err)
+	}
+
+	if pidFile != "" {
+		if err := writePIDFile(pidFile); err != nil {
+			log.Fatalf("failed to write pidfile: %v", err)
+		}
+	}
+	sdNotify("READY=1")
+	go watchdogPings()
+
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		writeShutdownSnapshot()
+		releaseInstanceLock()
+		db.Close()
+		os.Exit(0)
+	}()
+
+	log.Fatal(http.Serve(ln, nil))
+}
+
+// remoteAddr prefers the X-Forwarded-For header so logs show the real client
+// address when running behind a reverse proxy
+func remoteAddr(r *http.Request) string {
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		if i := strings.Index(fwd, ","); i != -1 {
+			return strings.TrimSpace(fwd[:i])
+		}
+		return fwd
+	}
+	return r.RemoteAddr
+}
+
+// remoteProto prefers the X-Forwarded-Proto header for the same reason
+func remoteProto(r *http.Request) string {
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		return proto
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// checkOrigin compares the Origin host against the host the client asked for,
+// honoring X-Forwarded-Host so origin checks still pass behind a reverse proxy
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	host := r.Host
+	if fwd := r.Header.Get("X-Forwarded-Host"); fwd != "" {
+		host = fwd
+	}
+
+	return strings.EqualFold(u.Host, host)
 }
 
 func initDataWithClient(clientID string) {
-	init := &InitClientData{}
+	init := &InitClientData{
+		Protocol:     ProtocolVersion,
+		Session:      sessionID,
+		Seq:          atomic.LoadInt64(&lastSeq),
+		Capabilities: serverCapabilities(),
+	}
+	stampServerTime(init)
 
-	for _, buf := range db.All(db.BucketTorrents) {
-		t := &TorrentInfo{}
-		if err := json.Unmarshal(buf, t); err != nil {
-			log.Fatalf("failed to init torrents for new client: %v", err)
-		} else {
-			init.Torrents = append(init.Torrents, t)
-		}
+	page, err := listTorrents(&TorrentListPayload{})
+	if err != nil {
+		log.Printf("failed to init torrents for new client: %v", err)
+		return
 	}
+	init.TorrentCount = page.Total
+	init.Torrents = page.Torrents
 
 	for _, buf := range db.All(db.BucketLabels) {
 		l := &Label{}
@@ -145,21 +513,151 @@ func initDataWithClient(clientID string) {
 	socket.Send(clientID, MsgClientInit, init)
 }
 
+// sendInitDelta sends a reconnecting client just the torrents that changed
+// since the sequence number it last saw
+func sendInitDelta(clientID string, sinceSeq int64, gz bool) error {
+	init := &InitClientData{
+		Protocol:     ProtocolVersion,
+		Session:      sessionID,
+		Seq:          atomic.LoadInt64(&lastSeq),
+		Capabilities: serverCapabilities(),
+	}
+	stampServerTime(init)
+
+	allInfo, err := GetAllTorrentInfo()
+	if err != nil {
+		return err
+	}
+
+	// the delta isn't paged, a reconnecting client's change set is bounded by
+	// how long it was away rather than the size of the whole collection
+	init.TorrentCount = len(allInfo)
+	for _, info := range allInfo {
+		if info.Seq > sinceSeq {
+			init.Torrents = append(init.Torrents, info)
+		}
+	}
+
+	for _, buf := range db.All(db.BucketLabels) {
+		l := &Label{}
+		if err := json.Unmarshal(buf, l); err != nil {
+			return err
+		}
+		init.Labels = append(init.Labels, l)
+	}
+
+	// a huge delta goes out in chunks so one frame never has to carry the
+	// whole library, keeping each write under the message size limits
+	if len(init.Torrents) > initChunkSize {
+		torrents := init.Torrents
+		init.Torrents = nil
+		init.Partial = true
+		if err := socket.Send(clientID, MsgClientInit, init); err != nil {
+			return err
+		}
+		return sendInitChunks(clientID, torrents, gz)
+	}
+
+	return socket.Send(clientID, MsgClientInit, init)
+}
+
+// initChunkSize is how many torrents ride in one MsgClientInitPartial frame
+const initChunkSize = 200
+
+// sendInitChunks streams the torrent list in fixed-size frames, gzipping
+// each chunk when the client asked for it
+func sendInitChunks(clientID string, torrents []*TorrentInfo, gz bool) error {
+	for len(torrents) > 0 {
+		n := initChunkSize
+		if n > len(torrents) {
+			n = len(torrents)
+		}
+
+		part := &InitPartialData{Done: n == len(torrents)}
+		if gz {
+			packed, err := gzipTorrents(torrents[:n])
+			if err != nil {
+				return err
+			}
+			part.TorrentsGz = packed
+		} else {
+			part.Torrents = torrents[:n]
+		}
+
+		if err := socket.Send(clientID, MsgClientInitPartial, part); err != nil {
+			return err
+		}
+		torrents = torrents[n:]
+	}
+	return nil
+}
+
+// gzipTorrents packs a torrent slice as base64-wrapped gzipped JSON
+func gzipTorrents(torrents []*TorrentInfo) (string, error) {
+	buf, err := json.Marshal(torrents)
+	if err != nil {
+		return "", err
+	}
+
+	var packed bytes.Buffer
+	zw := gzip.NewWriter(&packed)
+	if _, err := zw.Write(buf); err != nil {
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(packed.Bytes()), nil
+}
+
+// stampServerTime records the server's clock and zone on an init payload
+func stampServerTime(init *InitClientData) {
+	now := time.Now()
+	init.ServerTime = now.Format(time.RFC3339)
+	init.Timezone = now.Location().String()
+}
+
 func bootstrapTorrents() {
+	// one unreadable record shouldn't take the whole server down with it
+	quarantineCorruptTorrents()
+
+	// finish any status transition a crash cut short before acting on the
+	// statuses below
+	reconcileIntents()
+
+	// drop queue entries whose torrent is gone and requeue stranded ones
+	// before any slot is handed out
+	if _, err := repairQueue(); err != nil {
+		log.Printf("queue repair failed: %v", err)
+	}
+
 	for _, buf := range db.All(db.BucketTorrents) {
 		info := &TorrentInfo{}
 		if err := json.Unmarshal(buf, info); err != nil {
-			log.Fatalf("failed to restore saved torrent: %v", err)
+			log.Printf("failed to restore saved torrent: %v", err)
+			continue
+		}
+
+		if info.Seq > lastSeq {
+			lastSeq = info.Seq
 		}
+		indexTorrent(info)
 
 		switch info.Status {
 		case StatusActive:
-			queue.ForceNext(info.Hash)
+			if info.ForceStarted {
+				// forced torrents never held a queue slot, bring them
+				// straight back up
+				go startTorrent(info.Hash)
+			} else {
+				queue.ForceNext(info.Hash)
+			}
 		case StatusPending:
 			// this is a pretty narrow case: a torrent has StatusPending before it ever reaches
 			// the Queue (where then status then changes)
 			if err := addTorrentByMagnet(info.Magnet); err != nil {
-				log.Fatalf("failed to add pending torrent by magnet: %v", err)
+				log.Printf("failed to add pending torrent by magnet: %v", err)
 			}
 		default:
 			break
@@ -1,58 +1,793 @@
 package main
 
 import (
+	"bytes"
+	"encoding/base64"
 	"errors"
+	"fmt"
+	"io"
 	"log"
 	"os"
 	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/anacrolix/torrent"
 	"github.com/anacrolix/torrent/metainfo"
 
+	socket "github.com/akillmer/go-socket"
+	db "github.com/akillmer/riptide/database"
+	"github.com/akillmer/riptide/fs"
 	"github.com/akillmer/riptide/queue"
 )
 
 var managedTorrents = sync.Map{}
 
+// tickSeconds is how often an active torrent's manager updates progress and
+// runs its state transition, set with -tick. Piece completions wake the loop
+// between ticks, and idle seeders stretch the interval by idleTickFactor
+// since nothing changes for them second to second
+var tickSeconds int
+
+// idleTickFactor multiplies the tick interval for seeding torrents, they
+// only need the occasional pass for ratio checks and seed-time accounting
+const idleTickFactor = 30
+
+// infoHashPattern matches a bare 40-character hex info-hash
+var infoHashPattern = regexp.MustCompile(`^[0-9a-fA-F]{40}$`)
+
+// normalizeMagnet accepts either a magnet URI or a bare info-hash, several
+// indexers only expose the hash and metadata resolves from the DHT anyway
+func normalizeMagnet(uri string) string {
+	uri = strings.TrimSpace(uri)
+	if infoHashPattern.MatchString(uri) {
+		return "magnet:?xt=urn:btih:" + strings.ToLower(uri)
+	}
+	return uri
+}
+
 func addTorrentByMagnet(uri string) error {
-	// make sure the client isn't holding this torrent already
+	return addTorrent(&TorrentAddPayload{Magnet: uri})
+}
+
+func addTorrent(opts *TorrentAddPayload) error {
+	uri := normalizeMagnet(opts.Magnet)
+	if opts.Torrent != "" {
+		fromFile, err := magnetFromTorrentData(opts.Torrent)
+		if err != nil {
+			return err
+		}
+		uri = fromFile
+	}
+
+	// make sure this torrent isn't already held, by the client or the db
 	magnet, err := metainfo.ParseMagnetURI(uri)
 	if err != nil {
 		return err
 	}
 
-	if _, ok := client.Torrent(magnet.InfoHash); ok {
-		return errors.New("torrent already exists")
+	if existing, err := GetTorrentInfo(magnet.InfoHash.String()); err == nil {
+		return &ConflictError{Hash: existing.Hash, Reason: "torrent already exists"}
 	}
 
-	t, err := client.AddMagnet(uri)
-	if err != nil {
-		return err
+	if _, ok := client.Torrent(magnet.InfoHash); ok {
+		return &ConflictError{Hash: magnet.InfoHash.String(), Reason: "torrent already exists"}
 	}
 
-	hash := t.InfoHash().String()
-	info, err := GetTorrentInfo(hash)
-	if err != nil {
+	hash := magnet.InfoHash.String()
+	info, infoErr := GetTorrentInfo(hash)
+	if infoErr != nil {
 		info = &TorrentInfo{
 			Hash:      hash,
 			TimeAdded: time.Now().Unix(),
 			Magnet:    uri,
+			Dir:       incompleteDir,
 		}
 		info.Status = StatusPending
+		if metaFetchSlots != nil {
+			info.MetaState = MetaQueued
+		}
 		info.SaveAndBroadcast()
-		<-t.GotInfo()
-		info.Name = t.Name()
-		info.TotalBytes = t.Length()
+		appendHistory(hash, "added", "")
+
+		// metadata resolution waits on a fetch slot and then on the swarm,
+		// and a dead magnet never finishes either. Waiting here would pin
+		// this dispatch worker for the duration, so resolution runs on its
+		// own goroutine and the add returns once the PENDING record is in
+		// place. The outcome reaches clients over broadcasts.
+		go resolveNewTorrent(uri, info, opts)
+		return nil
+	}
+
+	t, err := addMagnet(uri)
+	if err != nil {
+		return err
+	}
+	return enqueueTorrent(t, info, opts, false)
+}
+
+// resolveNewTorrent sees a freshly-added torrent through metadata
+// resolution and into the queue. It runs on its own goroutine per torrent,
+// so a magnet that never resolves wedges nothing but itself, and the wait
+// on the swarm is bounded by metaResolveTimeout so a dead magnet can't hold
+// a fetch slot forever.
+func resolveNewTorrent(uri string, info *TorrentInfo, opts *TorrentAddPayload) {
+	hash := info.Hash
+
+	// a slot bounds how many magnets sit in the client resolving
+	// metadata at once, the rest wait here showing MetaQueued
+	if metaFetchSlots != nil {
+		metaFetchSlots <- struct{}{}
+		if _, err := GetTorrentInfo(hash); err != nil {
+			// deleted while it waited for a slot
+			releaseMetaSlot()
+			return
+		}
+		info.MetaState = MetaResolving
+		info.SaveAndBroadcast()
+	}
+
+	t, err := addMagnet(uri)
+	if err != nil {
+		releaseMetaSlot()
+		db.Delete(db.BucketTorrents, hash)
+		socket.Broadcast(MsgTorrentDelete, hash)
+		logf("%s: %v", hash, err)
+		return
+	}
+
+	select {
+	case <-t.GotInfo():
+	case <-time.After(metaResolveTimeout):
+		releaseMetaSlot()
+		t.Drop()
+		if _, err := GetTorrentInfo(hash); err != nil {
+			return
+		}
+		info.MetaState = ""
+		info.Status = StatusError
+		info.Error = "metadata did not resolve in time"
+		info.SaveAndBroadcast()
+		recordTorrentError(hash, info.Error)
+		return
 	}
+	releaseMetaSlot()
 
-	info.Status = StatusQueued
+	// the torrent may have been deleted while its metadata resolved, a
+	// save now would resurrect the record
+	if _, err := GetTorrentInfo(hash); err != nil {
+		t.Drop()
+		return
+	}
+
+	info.MetaState = ""
+	info.Name = t.Name()
+	info.TotalBytes = t.Length()
+	info.Files = torrentFilePaths(t)
+	// the client keeps private torrents off DHT and PEX on its own,
+	// recording the flag lets clients and seeding rules see it too
+	if meta := t.Info(); meta.Private != nil {
+		info.Private = *meta.Private
+	}
+	applyRules(info)
+
+	// the same content can hide under a different info-hash
+	if dup := findContentDuplicate(info); dup != nil {
+		t.Drop()
+		db.Delete(db.BucketTorrents, hash)
+		socket.Broadcast(MsgTorrentDelete, hash)
+		logf("%s: same content as existing torrent %s, removed", hash, dup.Hash)
+		return
+	}
+
+	// explicit add options win over whatever the rules chose
+	if opts.LabelID != "" {
+		info.LabelID = opts.LabelID
+	}
+	if opts.SavePath != "" {
+		info.MoveTo = opts.SavePath
+	}
+	if opts.Priority != "" {
+		info.Priority = opts.Priority
+	}
+	if len(opts.SkipFiles) > 0 {
+		known := make(map[string]bool, len(info.Files))
+		for _, p := range info.Files {
+			known[p] = true
+		}
+		for _, p := range opts.SkipFiles {
+			if !known[p] {
+				t.Drop()
+				info.Status = StatusError
+				info.Error = fmt.Sprintf("torrent has no file %q to skip", p)
+				info.SaveAndBroadcast()
+				return
+			}
+		}
+		info.SkipFiles = opts.SkipFiles
+		info.TotalBytes = wantedBytes(t, opts.SkipFiles)
+	}
+
+	if err := enqueueTorrent(t, info, opts, true); err != nil {
+		recordTorrentError(hash, err.Error())
+	}
+}
+
+// enqueueTorrent settles a resolved torrent's initial status from the add
+// options and hands it to the queue
+func enqueueTorrent(t *torrent.Torrent, info *TorrentInfo, opts *TorrentAddPayload, isNew bool) error {
+	switch {
+	case opts.Paused:
+		info.Status = StatusStopped
+	case opts.SeedOnly:
+		if !info.DataPresent() {
+			t.Drop()
+			db.Delete(db.BucketTorrents, info.Hash)
+			socket.Broadcast(MsgTorrentDelete, info.Hash)
+			return errors.New("no existing data to seed")
+		}
+		info.Status = StatusSeeding
+		info.TimeSeeded = time.Now().Unix()
+		info.BytesCompleted = info.TotalBytes
+	case opts.SkipCheck && info.DataPresent():
+		info.Status = StatusDone
+		info.TimeFinished = time.Now().Unix()
+		info.BytesCompleted = info.TotalBytes
+	default:
+		info.Status = StatusQueued
+	}
+	if isNew {
+		// scripted rules get the last word, a Stop action keeps the
+		// torrent out of the queue entirely
+		runScriptRules(ScriptOnAdd, info)
+	}
 	info.SaveAndBroadcast()
 
 	t.Drop() // this torrent may be going into the queue for a while
 	// we need to keep open files to a minimum per torrent client
 
-	return queue.Add(info.Hash)
+	switch info.Status {
+	case StatusStopped, StatusDone:
+		// nothing to download or seed right now, the user starts it later
+		return nil
+	}
+
+	if opts.QueueTop {
+		// an explicit front-of-queue add overrides any ordering policy
+		return queue.AddFront(info.Hash)
+	}
+	if err := queue.Add(info.Hash); err != nil {
+		return err
+	}
+	reorderQueue()
+	return nil
+}
+
+// magnetFromTorrentData converts a base64-encoded .torrent file into its
+// magnet form, metadata resolves again from the swarm the same way it does
+// for the upload endpoint
+func magnetFromTorrentData(data string) (string, error) {
+	buf, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return "", fmt.Errorf("bad torrent data: %v", err)
+	}
+	if int64(len(buf)) > maxTorrentFileSize {
+		return "", errors.New("torrent file too large")
+	}
+
+	mi, err := metainfo.Load(bytes.NewReader(buf))
+	if err != nil {
+		return "", fmt.Errorf("not a torrent file: %v", err)
+	}
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		return "", fmt.Errorf("bad info dictionary: %v", err)
+	}
+
+	return mi.Magnet(info.Name, mi.HashInfoBytes()).String(), nil
+}
+
+// torrentFilePaths returns the torrent's file paths in sorted order
+// downloadWanted requests every file the user hasn't deselected and cancels
+// the rest, so a selection change lands on the live torrent without dropping
+// and re-adding it
+func downloadWanted(t *torrent.Torrent, skip []string) {
+	if len(skip) == 0 {
+		t.DownloadAll()
+		return
+	}
+
+	skipped := make(map[string]bool, len(skip))
+	for _, p := range skip {
+		skipped[p] = true
+	}
+	for _, f := range t.Files() {
+		if skipped[f.DisplayPath()] {
+			f.SetPriority(torrent.PiecePriorityNone)
+		} else {
+			f.SetPriority(torrent.PiecePriorityNormal)
+		}
+	}
+}
+
+// wantedBytes is the torrent's length minus any deselected files, what
+// TotalBytes should read so progress and the done check line up
+func wantedBytes(t *torrent.Torrent, skip []string) int64 {
+	if len(skip) == 0 {
+		return t.Length()
+	}
+
+	skipped := make(map[string]bool, len(skip))
+	for _, p := range skip {
+		skipped[p] = true
+	}
+	var total int64
+	for _, f := range t.Files() {
+		if !skipped[f.DisplayPath()] {
+			total += f.Length()
+		}
+	}
+	return total
+}
+
+func torrentFilePaths(t *torrent.Torrent) []string {
+	files := t.Files()
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = f.DisplayPath()
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// findContentDuplicate looks for a stored torrent with the exact same file
+// set as the given one, regardless of info-hash
+func findContentDuplicate(info *TorrentInfo) *TorrentInfo {
+	allInfo, err := GetAllTorrentInfo()
+	if err != nil {
+		return nil
+	}
+
+	for _, other := range allInfo {
+		if other.Hash == info.Hash || len(other.Files) == 0 {
+			continue
+		}
+		if len(other.Files) != len(info.Files) {
+			continue
+		}
+
+		same := true
+		for i := range info.Files {
+			if info.Files[i] != other.Files[i] {
+				same = false
+				break
+			}
+		}
+		if same {
+			return other
+		}
+	}
+
+	return nil
+}
+
+// validName rejects names that are empty or would escape the torrent's folder
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." &&
+		!strings.ContainsAny(name, `/\`)
+}
+
+func renameTorrent(hash, newName string) error {
+	if !validName(newName) {
+		return ErrInvalidName
+	}
+
+	return UpdateTorrent(hash, func(info *TorrentInfo) error {
+		oldPath := info.DataPath()
+		info.Name = newName
+
+		// only move the folder when the client isn't writing to it, otherwise
+		// new files would reappear under the old name; DiskName keeps the move
+		// logic pointed at the right folder either way
+		if info.Status != StatusActive && info.Status != StatusSeeding {
+			newPath := path.Join(downloadDir, newName)
+			if _, err := fs.Default.Stat(oldPath); err == nil {
+				if _, err := fs.Default.Stat(newPath); err == nil {
+					return errors.New("a folder with that name already exists")
+				}
+				if err := fs.Default.Rename(oldPath, newPath); err != nil {
+					return err
+				}
+				info.DiskName = newName
+			}
+		}
+
+		return nil
+	})
+}
+
+func renameTorrentFile(hash, filePath, newName string) error {
+	if !validName(newName) {
+		return ErrInvalidName
+	}
+
+	return UpdateTorrent(hash, func(info *TorrentInfo) error {
+		oldRel := filePath
+		if mapped, ok := info.FileNames[filePath]; ok {
+			oldRel = mapped
+		}
+
+		newRel := path.Join(path.Dir(oldRel), newName)
+		oldPath := path.Join(info.DataPath(), oldRel)
+		newPath := path.Join(info.DataPath(), newRel)
+
+		if _, err := fs.Default.Stat(newPath); err == nil {
+			return errors.New("a file with that name already exists")
+		}
+		if err := fs.Default.Rename(oldPath, newPath); err != nil {
+			return err
+		}
+
+		if info.FileNames == nil {
+			info.FileNames = make(map[string]string)
+		}
+		info.FileNames[filePath] = newRel
+
+		return nil
+	})
+}
+
+// labelFor returns the torrent's label, nil when it has none
+func labelFor(info *TorrentInfo) *Label {
+	if info.LabelID == "" {
+		return nil
+	}
+	label, err := GetLabel(info.LabelID)
+	if err != nil {
+		return nil
+	}
+	return label
+}
+
+// seedingAllowed unless the torrent's label says to never seed
+func seedingAllowed(info *TorrentInfo) bool {
+	if label := labelFor(info); label != nil && label.NoSeed {
+		return false
+	}
+	return true
+}
+
+// minRatioMet unless the torrent's label is a ratio group that demands a
+// stricter ratio before any stop or removal rule may apply
+func minRatioMet(info *TorrentInfo, ratio float64) bool {
+	if !trackerRequirementMet(info, ratio) {
+		return false
+	}
+	if label := labelFor(info); label != nil && label.MinRatio > 0 {
+		return ratio >= label.MinRatio
+	}
+	return true
+}
+
+// seedTimeUp when the torrent's label says to stop N days after seeding began
+func seedTimeUp(info *TorrentInfo) bool {
+	label := labelFor(info)
+	if label == nil || label.SeedDays <= 0 || info.TimeSeeded == 0 {
+		return false
+	}
+	deadline := time.Unix(info.TimeSeeded, 0).AddDate(0, 0, label.SeedDays)
+	return time.Now().After(deadline)
+}
+
+// seededEnough when the torrent's cumulative seed time has reached the
+// -seed-hours cap, private trackers often count hours seeded as an
+// alternative to ratio
+func seededEnough(info *TorrentInfo) bool {
+	if seedHours <= 0 {
+		return false
+	}
+	return info.SecondsSeeded >= int64(seedHours)*3600
+}
+
+// moveDoneData moves a completed torrent's payload into its label's MoveTo
+// directory, or out of the incomplete directory when one is in use. Without
+// an incomplete directory a symlink is left behind so the torrent client can
+// still find its data at the original path.
+// Completion actions decide what happens to a torrent's data once it's done.
+// MOVE relocates it outright, COPY and HARDLINK leave the original seeding in
+// place, MOVE_SYMLINK moves it and leaves a symlink behind — which breaks on
+// filesystems without symlinks, hence the choice.
+const (
+	ActionMove        = "MOVE"
+	ActionCopy        = "COPY"
+	ActionHardlink    = "HARDLINK"
+	ActionMoveSymlink = "MOVE_SYMLINK"
+)
+
+var completionActions = map[string]bool{
+	ActionMove:        true,
+	ActionCopy:        true,
+	ActionHardlink:    true,
+	ActionMoveSymlink: true,
+}
+
+func moveDoneData(info *TorrentInfo) {
+	moveTo := info.MoveTo
+	action := ""
+	if info.LabelID != "" {
+		if label, err := info.GetLabel(); err != nil {
+			log.Printf("failed to get label for done torrent: %v", err)
+		} else {
+			if moveTo == "" {
+				moveTo = label.MoveTo
+			}
+			action = label.CompletionAction
+			if action == "" && label.HardlinkMove {
+				action = ActionHardlink
+			}
+		}
+	}
+	if action == "" {
+		// the legacy behavior: move and leave a symlink so seeding carries
+		// on, except in split mode where the move is the whole point
+		action = ActionMoveSymlink
+		if incompleteDir != "" {
+			action = ActionMove
+		}
+	}
+
+	if moveTo == "" {
+		if incompleteDir == "" {
+			return
+		}
+		moveTo = downloadDir
+	}
+
+	oldPath := info.DataPath()
+	newPath := path.Join(moveTo, info.Name)
+	if oldPath == newPath {
+		clearPendingMove(info)
+		return
+	}
+
+	if err := fs.Default.MkdirAll(moveTo, 0755); err != nil {
+		log.Printf("failed to mkdir %s: %v", moveTo, err)
+		deferMove(info, moveTo)
+		return
+	}
+
+	if _, err := fs.Default.Stat(newPath); err == nil {
+		// two labels pointing at the same MoveTo, or two torrents sharing a
+		// name, would otherwise silently skip the move here
+		resolved := resolveMoveCollision(moveTo, info)
+		if resolved == "" {
+			log.Printf("failed moving done data to %s, already exists", newPath)
+			info.MoveWarning = fmt.Sprintf("%s already exists, move skipped", newPath)
+			appendHistory(info.Hash, "move collision", info.MoveWarning)
+			clearPendingMove(info)
+			return
+		}
+		info.MoveWarning = fmt.Sprintf("%s already exists, using %s", newPath, resolved)
+		appendHistory(info.Hash, "move collision", info.MoveWarning)
+		newPath = resolved
+	}
+
+	switch action {
+	case ActionHardlink:
+		// the library gets an independent copy, the original stays put so
+		// the client keeps seeding it without any symlink tricks
+		if err := linkDoneData(oldPath, newPath); err != nil {
+			log.Printf("failed linking done data: %v", err)
+			deferMove(info, moveTo)
+		} else {
+			clearPendingMove(info)
+			appendHistory(info.Hash, "copied", newPath)
+		}
+
+	case ActionCopy:
+		if err := copyDoneData(oldPath, newPath); err != nil {
+			log.Printf("failed copying done data: %v", err)
+			deferMove(info, moveTo)
+		} else {
+			clearPendingMove(info)
+			appendHistory(info.Hash, "copied", newPath)
+		}
+
+	default: // ActionMove and ActionMoveSymlink
+		if err := fs.Default.Rename(oldPath, newPath); err != nil {
+			// a rename can't cross filesystems, fall back to a verified
+			// copy and only then drop the source
+			if copyErr := copyDoneData(oldPath, newPath); copyErr != nil {
+				log.Printf("failed moving done data: %v", err)
+				deferMove(info, moveTo)
+				return
+			}
+			if err := fs.Default.RemoveAll(oldPath); err != nil {
+				log.Printf("failed removing moved data source: %v", err)
+			}
+		}
+		clearPendingMove(info)
+		appendHistory(info.Hash, "moved", newPath)
+
+		if action == ActionMoveSymlink {
+			if err := fs.Default.Symlink(newPath, oldPath); err != nil {
+				log.Printf("failed making symlink to done data: %v", err)
+			}
+		} else {
+			info.Dir = path.Dir(newPath)
+			info.DiskName = ""
+			if base := path.Base(newPath); base != info.Name {
+				info.DiskName = base
+			}
+		}
+	}
+}
+
+// resolveMoveCollision picks an alternate destination when the torrent's
+// spot under moveTo is already taken, per the -move-collision policy. An
+// empty result means give up and skip the move
+func resolveMoveCollision(moveTo string, info *TorrentInfo) string {
+	switch moveCollision {
+	case "subdir":
+		// a per-torrent subdirectory named by the short hash, stable across
+		// retries so a deferred move lands in the same place
+		p := path.Join(moveTo, shortHash(info.Hash), info.Name)
+		if _, err := fs.Default.Stat(p); err != nil {
+			return p
+		}
+
+	case "suffix":
+		for i := 2; i <= 100; i++ {
+			p := path.Join(moveTo, fmt.Sprintf("%s (%d)", info.Name, i))
+			if _, err := fs.Default.Stat(p); err != nil {
+				return p
+			}
+		}
+	}
+	return ""
+}
+
+// shortHash is the first 8 hex digits of the torrent's hash, enough to tell
+// torrents apart in a directory name
+func shortHash(hash string) string {
+	if len(hash) > 8 {
+		return hash[:8]
+	}
+	return hash
+}
+
+// linkDoneData mirrors the payload at dest using hardlinks, falling back to
+// a plain copy for links that would cross filesystems
+func linkDoneData(src, dest string) error {
+	return fs.Default.Walk(src, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := dest
+		if rel != "." {
+			target = filepath.Join(dest, rel)
+		}
+
+		if fi.IsDir() {
+			return fs.Default.MkdirAll(target, 0755)
+		}
+		if err := fs.Default.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := fs.Default.Link(p, target); err != nil {
+			return copyFile(p, target)
+		}
+		return nil
+	})
+}
+
+// copyDoneData mirrors the payload at dest with fresh copies, verifying each
+// file's size on the far side before calling it good
+func copyDoneData(src, dest string) error {
+	return fs.Default.Walk(src, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := dest
+		if rel != "." {
+			target = filepath.Join(dest, rel)
+		}
+
+		if fi.IsDir() {
+			return fs.Default.MkdirAll(target, 0755)
+		}
+		if err := fs.Default.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := copyFile(p, target); err != nil {
+			return err
+		}
+
+		st, err := fs.Default.Stat(target)
+		if err != nil {
+			return err
+		}
+		if st.Size() != fi.Size() {
+			return fmt.Errorf("copy of %s came up short: %d of %d bytes", rel, st.Size(), fi.Size())
+		}
+		return nil
+	})
+}
+
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := fs.Default.Create(dest)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// startStoppedTorrent queues a stopped or errored torrent again
+func startStoppedTorrent(hash string) error {
+	err := UpdateTorrent(hash, func(info *TorrentInfo) error {
+		switch info.Status {
+		case StatusStopped, StatusError:
+			info.Status = StatusQueued
+			info.Error = ""
+			return nil
+		}
+		return errors.New("torrent is not stopped")
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := queue.Add(hash); err != nil {
+		return err
+	}
+	reorderQueue()
+	return nil
+}
+
+// redownloadTorrent explicitly re-fetches a torrent whose data went missing
+func redownloadTorrent(hash string) error {
+	err := UpdateTorrent(hash, func(info *TorrentInfo) error {
+		if info.Status != StatusError {
+			return errors.New("torrent is not in an error state")
+		}
+		info.Status = StatusQueued
+		info.Error = ""
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := queue.Add(hash); err != nil {
+		return err
+	}
+	reorderQueue()
+	return nil
 }
 
 func stopTorrent(hash string) {
@@ -67,7 +802,11 @@ func startTorrent(hash string) {
 	closeSignal := make(chan struct{})
 	managedTorrents.Store(hash, closeSignal)
 	progress := &TorrentProgress{Hash: hash}
-	ticker := time.NewTicker(time.Second)
+	tick := time.Duration(tickSeconds) * time.Second
+	if tick <= 0 {
+		tick = time.Second
+	}
+	ticker := time.NewTicker(tick)
 
 	info, err := GetTorrentInfo(hash)
 	if err != nil {
@@ -75,15 +814,68 @@ func startTorrent(hash string) {
 		return
 	}
 
-	if t, err := client.AddMagnet(info.Magnet); err != nil {
-		log.Printf("client failed to add magnet: %v", err)
+	// note the activation so a crash before the client holds the torrent
+	// reconciles back to QUEUED instead of a stranded ACTIVE record
+	beginIntent(hash, IntentStart)
+
+	progress.SeedFrom(info)
+	setActivePriority(hash, info.Priority)
+	defer clearActivePriority(hash)
+
+	// a torrent resuming its seed keeps (or re-claims) a seed slot, a full
+	// house doesn't kick out a seed that was already running
+	if info.Status == StatusSeeding {
+		queue.AcquireSeedSlot(hash)
+	}
+
+	// if the payload was deleted or the disk unmounted we don't want to
+	// quietly re-download everything, flag it for the user instead
+	if (info.Status == StatusSeeding || info.Status == StatusDone) && !info.DataPresent() {
+		info.Status = StatusError
+		info.Error = "data missing"
+		info.SaveAndBroadcast()
+		appendHistory(hash, "errored", info.Error)
+		managedTorrents.Delete(hash)
+		queue.Done(hash)
+		endIntent(hash)
+		return
+	}
+
+	t, err := addMagnet(info.Magnet)
+	if err != nil {
+		// usually a transient tracker or network problem, let the backoff
+		// retry it rather than leaving the torrent stranded
+		reason := err.Error()
+		recordTorrentError(hash, fmt.Sprintf("client failed to add magnet: %v", err))
+		UpdateTorrent(hash, func(info *TorrentInfo) error {
+			info.Status = StatusError
+			info.Error = reason
+			return nil
+		})
+		scheduleRetry(hash, reason)
+		managedTorrents.Delete(hash)
+		queue.Done(hash)
+		endIntent(hash)
 		return
-	} else if info.Status == StatusActive {
+	}
+	applyLabelLimits(hash, info.LabelID)
+	if info.Status == StatusActive {
 		if t.Info() == nil {
 			<-t.GotInfo()
 		}
-		t.DownloadAll()
+		downloadWanted(t, info.SkipFiles)
 	}
+	// the client holds the torrent now, from here the normal startup path
+	// recovers an interrupted session on its own
+	endIntent(hash)
+
+	// piece completions wake the loop between ticks so progress reflects
+	// them promptly even when the interval is long
+	pieces := t.SubscribePieceStateChanges()
+	defer pieces.Close()
+	interval := tick
+	lastTick := time.Now()
+	var seedCarry time.Duration
 
 	// whenever the torrent is stopped it's progress activity resets
 	defer func() {
@@ -100,12 +892,31 @@ func startTorrent(hash string) {
 		case <-closeSignal:
 			goto close
 		case <-ticker.C:
-			break
+		case <-pieces.Values:
+			// coalesce a burst of completions into one pass
+			for drained := false; !drained; {
+				select {
+				case <-pieces.Values:
+				default:
+					drained = true
+				}
+			}
 		}
 
+		now := time.Now()
+		elapsed := now.Sub(lastTick)
+		lastTick = now
+
 		t, ok := client.Torrent(metainfo.NewHashFromHex(hash))
 		if !ok {
-			log.Printf("client unexpectedly dropped %s", hash)
+			reason := "client unexpectedly dropped the torrent"
+			recordTorrentError(hash, reason)
+			UpdateTorrent(hash, func(info *TorrentInfo) error {
+				info.Status = StatusError
+				info.Error = reason
+				return nil
+			})
+			scheduleRetry(hash, reason)
 			break
 		} else if t.Info() == nil {
 			<-t.GotInfo()
@@ -113,72 +924,174 @@ func startTorrent(hash string) {
 
 		progress.Update(t)
 		progress.Broadcast()
+		broadcastFileProgress(hash)
+		recordTransfer(hash, progress.BytesUploaded, progress.BytesCompleted)
 
-		// grab the latest torrent info from the db, client mightve changed something
-		if latest, err := GetTorrentInfo(hash); err != nil {
-			log.Printf("failed to get updated info: %v", err)
-			break
-		} else {
-			info = latest
-		}
+		// the whole tick's transition runs inside the torrent's manager so
+		// it can't race with the API handlers
+		var status Status
+		var requeued, completing bool
+		err := UpdateTorrent(hash, func(info *TorrentInfo) error {
+			// checkpoint the counters, they ride along with every save
+			info.BytesCompleted = progress.BytesCompleted
+			info.BytesUploaded = progress.BytesUploaded
 
-		if info.Status == StatusQueued {
-			t.DownloadAll()
-			info.Status = StatusActive
-		}
+			if info.Status == StatusQueued {
+				downloadWanted(t, info.SkipFiles)
+				info.TotalBytes = wantedBytes(t, info.SkipFiles)
+				info.Status = StatusActive
+				if info.TimeStarted == 0 {
+					info.TimeStarted = time.Now().Unix()
+				}
+				appendHistory(hash, "started", "")
+			}
 
-		if info.Status == StatusActive {
-			if progress.BytesCompleted >= info.TotalBytes {
-				info.Status = StatusDone
+			if info.Status == StatusActive || info.Status == StatusSeeding {
+				info.LastActive = time.Now().Unix()
 			}
-		}
 
-		if info.Status == StatusDone {
-			if label, err := info.GetLabel(); err != nil {
-				log.Printf("failed to get label for done torrent: %v", err)
-			} else if label.MoveTo != "" {
-				if err := os.MkdirAll(label.MoveTo, 0755); err != nil {
-					log.Printf("failed to mkdir %s: %v", label.MoveTo, err)
+			if info.Status == StatusActive {
+				// coarse priority enforcement: shares shift whenever torrents
+				// start or stop, so rather than retuning limiters a torrent
+				// over its allocated share backs off its piece requests for a
+				// tick
+				if alloc := allocatedBpsDown(hash); alloc > 0 && progress.BpsDown > alloc {
+					t.CancelPieces(0, t.NumPieces())
 				} else {
-					oldPath := path.Join(downloadDir, info.Name)
-					newPath := path.Join(label.MoveTo, info.Name)
-
-					if _, err := os.Stat(newPath); err == nil {
-						log.Printf("failed moving done data to %s, already exists", newPath)
-					} else if err := os.Rename(oldPath, newPath); err != nil {
-						log.Printf("failed moving done data: %v", err)
-					} else if err := os.Symlink(newPath, oldPath); err != nil {
-						log.Printf("failed making symlink to done data: %v", err)
+					downloadWanted(t, info.SkipFiles)
+					applyPieceOrder(t, info.PieceOrder, info.SkipFiles)
+				}
+
+				if progress.BytesCompleted >= info.TotalBytes {
+					info.Status = StatusDone
+					info.TimeFinished = time.Now().Unix()
+					// a clean finish pays back the retry budget
+					info.RetryCount = 0
+					info.NextRetry = 0
+					appendHistory(hash, "finished", "")
+					// scripted rules see the completion before the seeding
+					// decision and the move, so a MoveTo lands in time and
+					// a Stop forgoes the seed
+					runScriptRules(ScriptOnComplete, info)
+				} else if progress.Stalled && progress.ActivePeers == 0 && !info.ForceStarted {
+					// a dead torrent shouldn't hold an active slot, send it
+					// to the back of the queue and let the next one run
+					info.Status = StatusQueued
+					appendHistory(hash, "stalled", "requeued")
+					requeued = true
+					runScriptRules(ScriptOnStall, info)
+					if info.Status == StatusStopped {
+						// a rule turned the requeue into a stop
+						appendHistory(hash, "stopped", "by rule")
+						requeued = false
 					}
 				}
 			}
 
-			if globalRatio != -1 && progress.Ratio < globalRatio {
-				info.Status = StatusSeeding
+			if info.Status == StatusDone {
+				if globalRatio != -1 && progress.Ratio < globalRatio &&
+					seedingAllowed(info) &&
+					(info.ForceStarted || queue.AcquireSeedSlot(hash)) {
+					info.Status = StatusSeeding
+					info.TimeSeeded = time.Now().Unix()
+				}
+
+				// the queue write and the record save can't share a bolt
+				// transaction, note the completion so a crash between the
+				// two reconciles forward at the next boot
+				completing = true
+				beginIntent(hash, IntentComplete)
+				queue.Done(hash)
 			}
 
-			queue.Done(hash)
-		}
+			if info.Status == StatusSeeding {
+				// the counter rides along with the tick's save, surviving
+				// stops and restarts; credit wall time since the loop no
+				// longer passes once a second, carrying sub-second change
+				seedCarry += elapsed
+				info.SecondsSeeded += int64(seedCarry / time.Second)
+				seedCarry %= time.Second
 
-		if info.Status == StatusSeeding {
-			if progress.Ratio >= globalRatio {
-				info.Status = StatusDone
+				if !info.DataPresent() {
+					info.Status = StatusError
+					info.Error = "data missing"
+					appendHistory(hash, "errored", info.Error)
+				} else if (progress.Ratio >= globalRatio || seedTimeUp(info) || seededEnough(info)) &&
+					minRatioMet(info, progress.Ratio) {
+					info.Status = StatusDone
+				}
+			}
+
+			if info.Status == StatusDone {
+				moveDoneData(info)
 			}
+
+			status = info.Status
+			return nil
+		})
+
+		if err != nil {
+			recordTorrentError(hash, fmt.Sprintf("failed to update torrent: %v", err))
+			scheduleRetry(hash, err.Error())
+			break
+		}
+		if completing {
+			endIntent(hash)
 		}
 
-		info.SaveAndBroadcast()
+		if requeued {
+			if err := queue.Add(hash); err != nil {
+				log.Printf("failed to requeue stalled torrent: %v", err)
+			}
+			reorderQueue()
+			break
+		}
 
-		if info.Status == StatusDone {
+		// StatusStopped only shows up here when a scripted rule stopped the
+		// torrent outright, the close path settles it like a user stop
+		if status == StatusDone || status == StatusError || status == StatusStopped {
+			if status == StatusDone {
+				go maybeUnpack(hash)
+			}
 			break
 		}
+
+		// idle seeders generate no piece events and don't need polling at
+		// the download cadence, stretch the tick until the status changes
+		next := tick
+		if status == StatusSeeding {
+			next = tick * idleTickFactor
+		}
+		if next != interval {
+			ticker.Stop()
+			ticker = time.NewTicker(next)
+			interval = next
+		}
 	}
 
 close:
+	// every manager exit funnels through here, the stop intent only matters
+	// when the record hasn't settled into a terminal status yet
+	beginIntent(hash, IntentStop)
+
+	// one last checkpoint so a stop doesn't lose the session's counters
+	err = UpdateTorrent(hash, func(info *TorrentInfo) error {
+		info.BytesCompleted = progress.BytesCompleted
+		info.BytesUploaded = progress.BytesUploaded
+		return nil
+	})
+	if err != nil {
+		log.Printf("failed to checkpoint stopped torrent: %v", err)
+	}
+
 	if t, ok := client.Torrent(metainfo.NewHashFromHex(hash)); ok {
 		t.Drop()
 	}
 	ticker.Stop()
+	closeTransfer(hash)
 	managedTorrents.Delete(hash)
 	close(closeSignal)
+	queue.ReleaseSeedSlot(hash)
 	queue.Done(hash)
+	endIntent(hash)
 }
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/binary"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Zero-dependency mDNS/DNS-SD announcement so LAN clients can find the web
+// UI without typing an IP. The responder answers PTR queries for
+// _http._tcp.local with an SRV/TXT/A record set and multicasts a gratuitous
+// announcement at startup, which is the subset of RFC 6762 that service
+// browsers actually need. -no-mdns turns it off.
+
+// noMDNS disables the responder for locked-down networks
+var noMDNS bool
+
+const (
+	mdnsService = "_http._tcp.local"
+	mdnsTTL     = 120
+
+	typePTR uint16 = 12
+	typeTXT uint16 = 16
+	typeSRV uint16 = 33
+	typeA   uint16 = 1
+	typeANY uint16 = 255
+)
+
+// announceMDNS runs the responder until the socket dies, it's a goroutine
+// for the lifetime of the server
+func announceMDNS(port, basePath string) {
+	if noMDNS {
+		return
+	}
+
+	group := &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		log.Printf("mdns disabled, failed to join multicast group: %v", err)
+		return
+	}
+
+	resp := buildMDNSResponse(port, basePath)
+	// a couple of gratuitous announcements get browsers that are already
+	// open to show the server right away
+	for i := 0; i < 2; i++ {
+		conn.WriteToUDP(resp, group)
+		time.Sleep(time.Second)
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			log.Printf("mdns responder stopped: %v", err)
+			return
+		}
+		if mdnsWantsService(buf[:n]) {
+			// answers multicast per RFC 6762 so every cache on the
+			// segment gets refreshed
+			conn.WriteToUDP(buildMDNSResponse(port, basePath), group)
+		}
+	}
+}
+
+// mdnsWantsService reports whether the packet is a query for our service
+func mdnsWantsService(pkt []byte) bool {
+	if len(pkt) < 12 || pkt[2]&0x80 != 0 { // only queries
+		return false
+	}
+	questions := int(binary.BigEndian.Uint16(pkt[4:6]))
+	off := 12
+
+	for i := 0; i < questions; i++ {
+		name, next, ok := decodeDNSName(pkt, off)
+		if !ok || next+4 > len(pkt) {
+			return false
+		}
+		qtype := binary.BigEndian.Uint16(pkt[next : next+2])
+		off = next + 4
+
+		if strings.EqualFold(name, mdnsService) && (qtype == typePTR || qtype == typeANY) {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeDNSName reads an uncompressed name, following at most one
+// compression pointer, which covers every query wire format seen in the wild
+func decodeDNSName(pkt []byte, off int) (name string, next int, ok bool) {
+	var labels []string
+	jumped := false
+	next = -1
+
+	for hops := 0; hops < 64; hops++ {
+		if off >= len(pkt) {
+			return "", 0, false
+		}
+		l := int(pkt[off])
+
+		if l&0xc0 == 0xc0 { // compression pointer
+			if off+1 >= len(pkt) {
+				return "", 0, false
+			}
+			if !jumped {
+				next = off + 2
+				jumped = true
+			}
+			off = (l&0x3f)<<8 | int(pkt[off+1])
+			continue
+		}
+		if l == 0 {
+			if !jumped {
+				next = off + 1
+			}
+			return strings.Join(labels, "."), next, true
+		}
+		if off+1+l > len(pkt) {
+			return "", 0, false
+		}
+		labels = append(labels, string(pkt[off+1:off+1+l]))
+		off += 1 + l
+	}
+	return "", 0, false
+}
+
+func encodeDNSName(name string) []byte {
+	var out []byte
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 {
+			continue
+		}
+		out = append(out, byte(len(label)))
+		out = append(out, label...)
+	}
+	return append(out, 0)
+}
+
+func appendRecord(pkt []byte, name string, rtype uint16, cacheFlush bool, rdata []byte) []byte {
+	pkt = append(pkt, encodeDNSName(name)...)
+	class := uint16(1)
+	if cacheFlush {
+		class |= 0x8000
+	}
+	pkt = binary.BigEndian.AppendUint16(pkt, rtype)
+	pkt = binary.BigEndian.AppendUint16(pkt, class)
+	pkt = binary.BigEndian.AppendUint32(pkt, mdnsTTL)
+	pkt = binary.BigEndian.AppendUint16(pkt, uint16(len(rdata)))
+	return append(pkt, rdata...)
+}
+
+// buildMDNSResponse assembles the PTR/SRV/TXT/A record set for the UI
+func buildMDNSResponse(port, basePath string) []byte {
+	host := mdnsHostname()
+	target := host + ".local"
+	instance := "riptide-" + host + "." + mdnsService
+
+	portNum, _ := strconv.Atoi(port)
+	addrs := localIPv4Addrs()
+
+	pkt := make([]byte, 12)
+	binary.BigEndian.PutUint16(pkt[2:4], 0x8400) // authoritative response
+	binary.BigEndian.PutUint16(pkt[6:8], uint16(3+len(addrs)))
+
+	pkt = appendRecord(pkt, mdnsService, typePTR, false, encodeDNSName(instance))
+
+	srv := make([]byte, 6)
+	binary.BigEndian.PutUint16(srv[4:6], uint16(portNum))
+	srv = append(srv, encodeDNSName(target)...)
+	pkt = appendRecord(pkt, instance, typeSRV, true, srv)
+
+	txt := []byte{}
+	for _, kv := range []string{"path=" + basePath, "srv=riptide"} {
+		txt = append(txt, byte(len(kv)))
+		txt = append(txt, kv...)
+	}
+	pkt = appendRecord(pkt, instance, typeTXT, true, txt)
+
+	for _, ip := range addrs {
+		pkt = appendRecord(pkt, target, typeA, true, ip)
+	}
+	return pkt
+}
+
+// mdnsHostname is the machine's name cut down to a single DNS label
+func mdnsHostname() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		return "riptide"
+	}
+	if i := strings.IndexByte(host, '.'); i > 0 {
+		host = host[:i]
+	}
+	return host
+}
+
+// localIPv4Addrs is every non-loopback IPv4 address to advertise
+func localIPv4Addrs() [][]byte {
+	var out [][]byte
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return out
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() {
+			continue
+		}
+		if v4 := ipNet.IP.To4(); v4 != nil {
+			out = append(out, []byte(v4))
+		}
+	}
+	return out
+}
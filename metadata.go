@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// TorrentMetadata is the detail view of a torrent's metainfo, served as
+// MsgTorrentMetadata
+type TorrentMetadata struct {
+	Hash         string `json:"hash"`
+	Comment      string `json:"comment,omitempty"`
+	CreatedBy    string `json:"createdBy,omitempty"`
+	CreationDate int64  `json:"creationDate,omitempty"`
+	PieceLength  int64  `json:"pieceLength,omitempty"`
+	Private      bool   `json:"private"`
+	Magnet       string `json:"magnet"`
+}
+
+// getTorrentMetadata collects the metainfo fields for one torrent. Comment,
+// creator, and creation date live in the top-level metainfo which magnets
+// don't carry, so they're only present while the client holds a torrent
+// that was loaded from a .torrent file.
+func getTorrentMetadata(hash string) (*TorrentMetadata, error) {
+	info, err := GetTorrentInfo(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := &TorrentMetadata{
+		Hash:    hash,
+		Private: info.Private,
+		Magnet:  info.Magnet,
+	}
+
+	if t, ok := client.Torrent(metainfo.NewHashFromHex(hash)); ok {
+		mi := t.Metainfo()
+		meta.Comment = mi.Comment
+		meta.CreatedBy = mi.CreatedBy
+		meta.CreationDate = mi.CreationDate
+		if ti := t.Info(); ti != nil {
+			meta.PieceLength = ti.PieceLength
+		}
+	}
+
+	return meta, nil
+}
+
+// setTorrentMagnet replaces a torrent's stored magnet, e.g. to pick up new
+// trackers, as long as the new URI resolves to the same info-hash
+func setTorrentMagnet(hash, uri string) error {
+	uri = normalizeMagnet(uri)
+	magnet, err := metainfo.ParseMagnetURI(uri)
+	if err != nil {
+		return err
+	}
+	if magnet.InfoHash.String() != hash {
+		return errors.New("magnet resolves to a different info-hash")
+	}
+
+	return UpdateTorrent(hash, func(info *TorrentInfo) error {
+		info.Magnet = uri
+		return nil
+	})
+}
@@ -0,0 +1,40 @@
+package main
+
+import "time"
+
+// Bounding concurrent magnet resolutions. Every magnet waiting on its
+// metadata holds DHT traffic and peer connections, and a dump of dozens of
+// magnets at once would hold all of them together. Each torrent's resolver
+// takes a slot before handing its magnet to the client and the rest queue
+// up, with the sub-status on the torrent so the UI can say why a PENDING
+// torrent idles.
+
+// MetaState values, shown while a torrent's metadata resolves
+const (
+	MetaQueued    = "QUEUED"
+	MetaResolving = "RESOLVING"
+)
+
+// metaResolveTimeout bounds how long a new magnet may wait on the swarm for
+// its metadata, a dead one errors out instead of holding a slot forever
+const metaResolveTimeout = 30 * time.Minute
+
+// maxMetaFetches is the -meta-fetches cap, 0 lifts the limit
+var maxMetaFetches int
+
+// metaFetchSlots is the semaphore behind the cap, nil when unlimited
+var metaFetchSlots chan struct{}
+
+// initMetaFetchSlots sizes the semaphore once flags are parsed
+func initMetaFetchSlots() {
+	if maxMetaFetches > 0 {
+		metaFetchSlots = make(chan struct{}, maxMetaFetches)
+	}
+}
+
+// releaseMetaSlot frees a resolution slot, a no-op when unlimited
+func releaseMetaSlot() {
+	if metaFetchSlots != nil {
+		<-metaFetchSlots
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// pendingMoves tracks the retry attempt count per torrent whose completed
+// data couldn't be moved yet, e.g. the target was unmounted or unwritable
+var pendingMoves = sync.Map{}
+
+const (
+	moveRetryBase = time.Minute
+	moveRetryMax  = time.Hour
+)
+
+// deferMove flags the torrent as waiting to move its data and schedules a
+// retry, the destination is surfaced to clients via TorrentInfo
+func deferMove(info *TorrentInfo, moveTo string) {
+	info.PendingMove = moveTo
+	scheduleMoveRetry(info.Hash)
+}
+
+// clearPendingMove when the torrent's move succeeded or is no longer wanted
+func clearPendingMove(info *TorrentInfo) {
+	info.PendingMove = ""
+	pendingMoves.Delete(info.Hash)
+}
+
+// scheduleMoveRetry queues another move attempt with exponential backoff
+func scheduleMoveRetry(hash string) {
+	attempts := 0
+	if v, ok := pendingMoves.Load(hash); ok {
+		attempts = v.(int)
+	}
+	pendingMoves.Store(hash, attempts+1)
+
+	delay := moveRetryBase << uint(attempts)
+	if delay > moveRetryMax || delay <= 0 {
+		delay = moveRetryMax
+	}
+
+	time.AfterFunc(delay, func() {
+		retryMove(hash)
+	})
+}
+
+// retryMove attempts the torrent's pending move again. moveDoneData either
+// clears the pending state on success or schedules the next retry itself.
+func retryMove(hash string) {
+	if _, ok := pendingMoves.Load(hash); !ok {
+		return // resolved in the meantime
+	}
+
+	moved := false
+	err := UpdateTorrent(hash, func(info *TorrentInfo) error {
+		if info.PendingMove == "" {
+			pendingMoves.Delete(hash)
+			return nil
+		}
+		moveDoneData(info)
+		moved = info.PendingMove == ""
+		return nil
+	})
+	if moved {
+		// the data finally reached its destination, post-processing can run
+		go maybeUnpack(hash)
+	}
+	if err != nil {
+		// the torrent was likely deleted while a retry was queued
+		log.Printf("abandoning pending move for %s: %v", hash, err)
+		pendingMoves.Delete(hash)
+	}
+}
+
+// retryMoveNow resets the backoff and retries the torrent's pending move
+// immediately, for when the user has fixed whatever blocked it
+func retryMoveNow(hash string) error {
+	info, err := GetTorrentInfo(hash)
+	if err != nil {
+		return err
+	}
+	if info.PendingMove == "" {
+		return errors.New("torrent has no pending move")
+	}
+
+	pendingMoves.Store(hash, 0)
+	go retryMove(hash)
+	return nil
+}
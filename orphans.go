@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	db "github.com/akillmer/riptide/database"
+	"github.com/akillmer/riptide/fs"
+)
+
+// Orphan scanning for seedboxes that accumulate leftovers: data whose
+// torrent was deleted without its files, aborted moves, manual copies. The
+// scan walks the download directory, the incomplete directory, and every
+// label's MoveTo, and reports top-level entries no stored torrent claims.
+// Deletion is a separate, explicit request and only accepts paths the scan
+// itself would report.
+
+// OrphanEntry is one unreferenced file or directory with its total size
+type OrphanEntry struct {
+	Path  string `json:"path"`
+	Bytes int64  `json:"bytes"`
+}
+
+// OrphanReport is the MsgOrphanScan reply
+type OrphanReport struct {
+	Entries    []*OrphanEntry `json:"entries"`
+	TotalBytes int64          `json:"totalBytes"`
+}
+
+// OrphanDeletePayload for MsgOrphanDelete, every path must come from a scan
+type OrphanDeletePayload struct {
+	Paths []string `json:"paths"`
+}
+
+// orphanScanRoots is every directory torrent data legitimately lives in
+func orphanScanRoots() []string {
+	seen := map[string]bool{downloadDir: true}
+	roots := []string{downloadDir}
+	if incompleteDir != "" && !seen[incompleteDir] {
+		seen[incompleteDir] = true
+		roots = append(roots, incompleteDir)
+	}
+
+	for _, buf := range db.All(db.BucketLabels) {
+		label := &Label{}
+		if err := json.Unmarshal(buf, label); err != nil {
+			continue
+		}
+		if label.MoveTo != "" && !seen[label.MoveTo] {
+			seen[label.MoveTo] = true
+			roots = append(roots, label.MoveTo)
+		}
+	}
+	return roots
+}
+
+// referencedPaths is every top-level path a stored torrent could account
+// for: where its data sits now, and where a completion action may have left
+// a copy
+func referencedPaths() (map[string]bool, error) {
+	all, err := GetAllTorrentInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	refs := map[string]bool{}
+	for _, info := range all {
+		refs[info.DataPath()] = true
+
+		name := info.Name
+		if info.DiskName != "" {
+			name = info.DiskName
+		}
+		refs[path.Join(downloadDir, name)] = true
+		refs[path.Join(downloadDir, info.Name)] = true
+		if incompleteDir != "" {
+			refs[path.Join(incompleteDir, name)] = true
+		}
+		if info.MoveTo != "" {
+			refs[path.Join(info.MoveTo, info.Name)] = true
+		}
+		if info.LabelID != "" {
+			if label, err := info.GetLabel(); err == nil && label.MoveTo != "" {
+				refs[path.Join(label.MoveTo, info.Name)] = true
+				refs[path.Join(label.MoveTo, shortHash(info.Hash), info.Name)] = true
+			}
+		}
+	}
+	return refs, nil
+}
+
+// scanOrphans walks the scan roots and sizes up everything unreferenced
+func scanOrphans() (*OrphanReport, error) {
+	refs, err := referencedPaths()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &OrphanReport{Entries: []*OrphanEntry{}}
+	for _, root := range orphanScanRoots() {
+		var current *OrphanEntry
+
+		err := fs.Default.Walk(root, func(p string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			rel, relErr := filepath.Rel(root, p)
+			if relErr != nil || rel == "." {
+				return nil
+			}
+
+			if !strings.Contains(rel, string(filepath.Separator)) {
+				// a new top-level entry, referenced or dotfile means skip
+				current = nil
+				if refs[p] || strings.HasPrefix(rel, ".") {
+					if fi.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				current = &OrphanEntry{Path: p}
+				report.Entries = append(report.Entries, current)
+			}
+
+			if current != nil && !fi.IsDir() {
+				current.Bytes += fi.Size()
+				report.TotalBytes += fi.Size()
+			}
+			return nil
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// deleteOrphans removes the given paths, refusing anything a fresh scan
+// wouldn't report so a stale or hostile client can't delete live data
+func deleteOrphans(data *OrphanDeletePayload) error {
+	if len(data.Paths) == 0 {
+		return ErrBadRequest
+	}
+
+	report, err := scanOrphans()
+	if err != nil {
+		return err
+	}
+	orphaned := make(map[string]int64, len(report.Entries))
+	for _, entry := range report.Entries {
+		orphaned[entry.Path] = entry.Bytes
+	}
+
+	var freed int64
+	for _, p := range data.Paths {
+		bytes, ok := orphaned[p]
+		if !ok {
+			return fmt.Errorf("%s is not an orphan", p)
+		}
+		if err := fs.Default.RemoveAll(p); err != nil {
+			return err
+		}
+		freed += bytes
+	}
+
+	appendActivity("", "", "orphans removed",
+		fmt.Sprintf("%d entries, %d bytes freed", len(data.Paths), freed))
+	return nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+)
+
+// ProtocolVersion is bumped whenever a message payload changes shape in a way
+// that older clients can't handle. Clients report theirs via MsgClientInit.
+const ProtocolVersion = 1
+
+// ErrProtocolVersion when a client speaks a protocol this server doesn't
+var ErrProtocolVersion = errors.New("unsupported protocol version")
+
+// ClientHandshake is sent by a client as MsgClientInit to negotiate the
+// protocol. A reconnecting client may present the session token and sequence
+// number from its last MsgClientInit to receive only the torrents that
+// changed since, instead of the whole init payload.
+type ClientHandshake struct {
+	Protocol int    `json:"protocol"`
+	Session  string `json:"session,omitempty"`
+	Seq      int64  `json:"seq,omitempty"`
+	// Gzip opts in to gzipped torrent chunks in the init exchange, worth it
+	// for big libraries on top of the websocket's per-message deflate
+	Gzip bool `json:"gzip,omitempty"`
+	// Features lists optional behaviors the client supports, drawn from the
+	// Features the server advertised in ServerCapabilities
+	Features []string `json:"features,omitempty"`
+}
+
+// InitPartialData is one chunk of a large torrent list, frames follow an
+// InitClientData marked Partial and the last one carries Done. TorrentsGz
+// replaces Torrents with a gzipped, base64-encoded JSON array when the
+// client asked for gzip.
+type InitPartialData struct {
+	Torrents   []*TorrentInfo `json:"torrents,omitempty"`
+	TorrentsGz string         `json:"torrentsGz,omitempty"`
+	Done       bool           `json:"done,omitempty"`
+}
+
+// TorrentAddPayload for MsgTorrentAdd. A bare magnet string is still accepted
+// for older clients and behaves as if every option were false. Label, save
+// path, priority, and file selection land with the add itself instead of as
+// follow-up messages that could race the pending and queued transitions.
+type TorrentAddPayload struct {
+	Magnet string `json:"magnet"`
+	// Torrent is a base64-encoded .torrent file, an alternative to Magnet
+	Torrent string `json:"torrent,omitempty"`
+	// LabelID assigns a label at add time, overriding any label rules
+	LabelID string `json:"labelID,omitempty"`
+	// SavePath overrides where the completed data moves, like a rule's MoveTo
+	SavePath string `json:"savePath,omitempty"`
+	// Priority is the torrent's bandwidth class, empty means PriorityNormal
+	Priority string `json:"priority,omitempty"`
+	// SkipFiles deselects file paths before the torrent ever downloads
+	SkipFiles []string `json:"skipFiles,omitempty"`
+	// Paused adds the torrent as StatusStopped, it won't queue until started
+	Paused bool `json:"paused,omitempty"`
+	// QueueTop puts the torrent at the front of the queue instead of the back
+	QueueTop bool `json:"queueTop,omitempty"`
+	// SkipCheck trusts data already on disk and marks the torrent done
+	// without re-verifying it
+	SkipCheck bool `json:"skipCheck,omitempty"`
+	// SeedOnly requires the data to already exist and goes straight to seeding
+	SeedOnly bool `json:"seedOnly,omitempty"`
+}
+
+// TorrentDeletePayload for MsgTorrentDelete, Archive keeps the torrent's
+// magnet and metadata around so it can be restored later
+type TorrentDeletePayload struct {
+	Hash     string `json:"hash"`
+	WithData bool   `json:"withData"`
+	Archive  bool   `json:"archive"`
+}
+
+// TorrentForceStartPayload for MsgTorrentForceStart, Off clears the flag
+// and puts the torrent back under the queue's limits
+type TorrentForceStartPayload struct {
+	Hash string `json:"hash"`
+	Off  bool   `json:"off,omitempty"`
+}
+
+// TorrentRenamePayload for MsgTorrentRename
+type TorrentRenamePayload struct {
+	Hash string `json:"hash"`
+	Name string `json:"name"`
+}
+
+// TorrentFileRenamePayload for MsgTorrentFileRename
+type TorrentFileRenamePayload struct {
+	Hash string `json:"hash"`
+	Path string `json:"path"`
+	Name string `json:"name"`
+}
+
+// TorrentMagnetSetPayload for MsgTorrentMagnetSet, the new magnet must keep
+// the torrent's info-hash
+type TorrentMagnetSetPayload struct {
+	Hash   string `json:"hash"`
+	Magnet string `json:"magnet"`
+}
+
+// TorrentPriorityPayload for MsgTorrentPriority
+type TorrentPriorityPayload struct {
+	Hash     string `json:"hash"`
+	Priority string `json:"priority"`
+}
+
+// TorrentFilesPayload for MsgTorrentFilesSet, SkipFiles replaces the
+// torrent's deselected-file list wholesale, an empty list wants everything
+type TorrentFilesPayload struct {
+	Hash      string   `json:"hash"`
+	SkipFiles []string `json:"skipFiles"`
+}
+
+// PieceWindowPayload for MsgTorrentPieceWindow, the byte range is relative
+// to the start of the named file and Priority is HIGH, READAHEAD, or NOW
+// (empty: HIGH)
+type PieceWindowPayload struct {
+	Hash     string `json:"hash"`
+	Path     string `json:"path"`
+	Start    int64  `json:"start"`
+	Length   int64  `json:"length"`
+	Priority string `json:"priority,omitempty"`
+}
+
+// PieceOrderPayload for MsgTorrentPieceOrder, Order is rarest, sequential,
+// or random (empty: rarest)
+type PieceOrderPayload struct {
+	Hash  string `json:"hash"`
+	Order string `json:"order"`
+}
+
+// LabelSetPayload for MsgTorrentLabelSet, a nil LabelID clears the label
+type LabelSetPayload struct {
+	Hash    string  `json:"hash"`
+	LabelID *string `json:"labelID"`
+}
+
+// ConflictError is returned when an added torrent duplicates one that already
+// exists, carrying the existing torrent's hash so the client can act on it
+type ConflictError struct {
+	Hash   string `json:"hash"`
+	Reason string `json:"reason"`
+}
+
+func (e *ConflictError) Error() string {
+	return e.Reason
+}
+
+// Payload shape limits. The websocket already caps whole-message size, but
+// within that a payload could still be one absurd string, array, or tower
+// of nesting that no handler expects. Every typed payload passes these
+// checks before the strict decode, and violations come back as structured
+// errors naming the offending field.
+const (
+	// maxPayloadString leaves room for a base64 .torrent in an add payload
+	maxPayloadString = 1 << 20
+	maxPayloadArray  = 10000
+	maxPayloadDepth  = 8
+	maxPayloadKey    = 256
+)
+
+// PayloadError names which part of a payload was rejected and why, clients
+// get it as a structured error payload
+type PayloadError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+func (e *PayloadError) Error() string {
+	if e.Field == "" {
+		return e.Reason
+	}
+	return e.Field + ": " + e.Reason
+}
+
+// checkPayloadShape walks the decoded JSON before any handler sees it,
+// bounding string lengths, array sizes, and nesting depth
+func checkPayloadShape(v interface{}, field string, depth int) error {
+	if depth > maxPayloadDepth {
+		return &PayloadError{Field: field, Reason: "nested too deeply"}
+	}
+
+	switch v := v.(type) {
+	case string:
+		if len(v) > maxPayloadString {
+			return &PayloadError{Field: field, Reason: "string too long"}
+		}
+	case []interface{}:
+		if len(v) > maxPayloadArray {
+			return &PayloadError{Field: field, Reason: "array too large"}
+		}
+		for _, item := range v {
+			if err := checkPayloadShape(item, field, depth+1); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		for key, val := range v {
+			if len(key) > maxPayloadKey {
+				return &PayloadError{Field: field, Reason: "field name too long"}
+			}
+			name := key
+			if field != "" {
+				name = field + "." + key
+			}
+			if err := checkPayloadShape(val, name, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// decodePayload re-encodes a socket payload and strictly decodes it into the
+// typed struct v, rejecting oversized shapes and any unknown fields
+func decodePayload(payload interface{}, v interface{}) error {
+	if err := checkPayloadShape(payload, "", 0); err != nil {
+		return err
+	}
+
+	buf, err := json.Marshal(payload)
+	if err != nil {
+		return ErrBadRequest
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return ErrBadRequest
+	}
+
+	return nil
+}
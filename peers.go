@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+
+	db "github.com/akillmer/riptide/database"
+	"github.com/anacrolix/torrent/iplist"
+)
+
+// Optional peer whitelisting: when a CIDR list is set, riptide only
+// exchanges data with peers inside it, everything else is dropped by the
+// torrent client's own block-list hook. Useful for private seedbox-to-home
+// transfers. Note the client's block list is IPv4-only, so a whitelist also
+// shuts out every IPv6 peer.
+
+// peerAllowlist inverts iplist.Ranger: an IP outside every allowed network
+// reports as blocked
+type peerAllowlist struct {
+	nets []*net.IPNet
+}
+
+func (w *peerAllowlist) Lookup(ip net.IP) (iplist.Range, bool) {
+	for _, n := range w.nets {
+		if n.Contains(ip) {
+			return iplist.Range{}, false
+		}
+	}
+	return iplist.Range{Description: "not in peer whitelist"}, true
+}
+
+func (w *peerAllowlist) NumRanges() int {
+	return len(w.nets)
+}
+
+const peerWhitelistKey = "peerWhitelist"
+
+// parseCIDRs accepts CIDR networks and bare IPs (treated as /32)
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		if !strings.Contains(c, "/") {
+			c += "/32"
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("bad peer whitelist entry %q: %v", c, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// getPeerWhitelist returns the stored CIDR list, empty means every peer is
+// welcome
+func getPeerWhitelist() []string {
+	cidrs := []string{}
+	if buf, err := db.Get(db.BucketSettings, peerWhitelistKey); err == nil {
+		json.Unmarshal(buf, &cidrs)
+	}
+	return cidrs
+}
+
+// setPeerWhitelist validates, persists, and applies the CIDR list to the
+// running client, an empty list removes the restriction
+func setPeerWhitelist(cidrs []string) error {
+	nets, err := parseCIDRs(cidrs)
+	if err != nil {
+		return err
+	}
+
+	if err := db.Put(db.BucketSettings, peerWhitelistKey, cidrs); err != nil {
+		return err
+	}
+
+	applyPeerWhitelist(nets)
+	return nil
+}
+
+// applyPeerWhitelist swaps the client's block list, nil lifts it
+func applyPeerWhitelist(nets []*net.IPNet) {
+	if len(nets) == 0 {
+		client.SetIPBlockList(nil)
+		return
+	}
+	client.SetIPBlockList(&peerAllowlist{nets: nets})
+}
+
+// loadPeerWhitelist applies the stored list at boot, falling back to the
+// -peer-whitelist flag when nothing is stored yet
+func loadPeerWhitelist(flagCIDRs string) {
+	cidrs := getPeerWhitelist()
+	if len(cidrs) == 0 && flagCIDRs != "" {
+		cidrs = strings.Split(flagCIDRs, ",")
+	}
+
+	nets, err := parseCIDRs(cidrs)
+	if err != nil {
+		log.Fatalf("invalid peer whitelist: %v", err)
+	}
+	applyPeerWhitelist(nets)
+}
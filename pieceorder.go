@@ -0,0 +1,89 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+
+	"github.com/anacrolix/torrent"
+)
+
+// Piece selection strategy, per torrent. The client's own picker is
+// rarest-first, which is what swarm health wants and stays the default.
+// Sequential keeps a small window of the earliest incomplete pieces hot so
+// the payload becomes playable front to back, random spreads the same
+// window across the torrent for archival pulls that shouldn't all hammer
+// the same region. The manager re-applies the order every tick since the
+// window moves as pieces finish.
+
+// pieceOrderWindow is how many incomplete pieces get bumped at a time, big
+// enough to keep requests pipelined without drowning the picker
+const pieceOrderWindow = 8
+
+// validPieceOrder reports whether the name is a strategy we support
+func validPieceOrder(order string) bool {
+	switch order {
+	case "", "rarest", "sequential", "random":
+		return true
+	}
+	return false
+}
+
+// applyPieceOrder bumps the current window of pieces for the torrent's
+// strategy, leaving rarest-first to the client when no order is set. Only
+// pieces belonging to wanted files are touched so a skipped file can't be
+// dragged in through a priority bump.
+func applyPieceOrder(t *torrent.Torrent, order string, skip []string) {
+	if order == "" || order == "rarest" || t.Info() == nil {
+		return
+	}
+	pieceLen := t.Info().PieceLength
+	if pieceLen == 0 {
+		return
+	}
+
+	skipped := make(map[string]bool, len(skip))
+	for _, p := range skip {
+		skipped[p] = true
+	}
+
+	var wanted []int
+	seen := map[int]bool{}
+	for _, f := range t.Files() {
+		if skipped[f.DisplayPath()] {
+			continue
+		}
+		begin := int(f.Offset() / pieceLen)
+		end := int((f.Offset() + f.Length() + pieceLen - 1) / pieceLen)
+		for i := begin; i < end && i < t.NumPieces(); i++ {
+			if seen[i] || t.PieceState(i).Complete {
+				continue
+			}
+			seen[i] = true
+			wanted = append(wanted, i)
+		}
+	}
+	if len(wanted) == 0 {
+		return
+	}
+
+	switch order {
+	case "sequential":
+		sort.Ints(wanted)
+		for n, i := range wanted {
+			if n == pieceOrderWindow {
+				break
+			}
+			t.Piece(i).SetPriority(torrent.PiecePriorityNow)
+		}
+	case "random":
+		rand.Shuffle(len(wanted), func(i, j int) {
+			wanted[i], wanted[j] = wanted[j], wanted[i]
+		})
+		for n, i := range wanted {
+			if n == pieceOrderWindow {
+				break
+			}
+			t.Piece(i).SetPriority(torrent.PiecePriorityHigh)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Piece priority windows for streaming front ends. A player that knows its
+// own buffer can ask for "bytes 0-50MB of file 2" instead of the server
+// guessing with a whole-file sequential flag; the window's pieces get raised
+// priority and everything else in the file drops back to normal.
+
+// ErrTorrentNotRunning means a piece window was asked of a torrent the
+// client doesn't currently hold
+var ErrTorrentNotRunning = errors.New("torrent is not running")
+
+// PieceWindowResult tells the streaming client which pieces its byte range
+// resolved to so it can watch progress against them
+type PieceWindowResult struct {
+	Begin int `json:"begin"`
+	End   int `json:"end"`
+}
+
+// applyWindowPriority raises one piece to the named urgency, HIGH when the
+// payload doesn't say. The priority type isn't exported by the torrent
+// package so the names resolve here instead of in a map
+func applyWindowPriority(p *torrent.Piece, name string) error {
+	switch name {
+	case "", "HIGH":
+		p.SetPriority(torrent.PiecePriorityHigh)
+	case "READAHEAD":
+		p.SetPriority(torrent.PiecePriorityReadahead)
+	case "NOW":
+		p.SetPriority(torrent.PiecePriorityNow)
+	default:
+		return fmt.Errorf("unknown window priority %q", name)
+	}
+	return nil
+}
+
+// setPieceWindow raises the priority of every piece covering the byte range
+// [start, start+length) of the named file, and resets the file's remaining
+// pieces to normal so an old window doesn't compete with the new one
+func setPieceWindow(data *PieceWindowPayload) (*PieceWindowResult, error) {
+	if data.Hash == "" || data.Path == "" || data.Start < 0 || data.Length <= 0 {
+		return nil, ErrBadRequest
+	}
+
+	t, ok := client.Torrent(metainfo.NewHashFromHex(data.Hash))
+	if !ok || t.Info() == nil {
+		return nil, ErrTorrentNotRunning
+	}
+
+	var file *torrent.File
+	for _, f := range t.Files() {
+		if f.DisplayPath() == data.Path {
+			file = f
+			break
+		}
+	}
+	if file == nil {
+		return nil, fmt.Errorf("torrent has no file %q", data.Path)
+	}
+	if data.Start >= file.Length() {
+		return nil, ErrBadRequest
+	}
+
+	end := data.Start + data.Length
+	if end > file.Length() {
+		end = file.Length()
+	}
+
+	pieceLen := t.Info().PieceLength
+	firstFile := int(file.Offset() / pieceLen)
+	lastFile := int((file.Offset() + file.Length() + pieceLen - 1) / pieceLen)
+	begin := int((file.Offset() + data.Start) / pieceLen)
+	final := int((file.Offset() + end + pieceLen - 1) / pieceLen)
+
+	for i := firstFile; i < lastFile; i++ {
+		if i >= begin && i < final {
+			if err := applyWindowPriority(t.Piece(i), data.Priority); err != nil {
+				return nil, err
+			}
+		} else {
+			t.Piece(i).SetPriority(torrent.PiecePriorityNormal)
+		}
+	}
+
+	return &PieceWindowResult{Begin: begin, End: final}, nil
+}
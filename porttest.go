@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Listen port helpers: -port-random picks a fresh ephemeral port each boot
+// (some trackers frown on long-lived well-known ports), and MsgPortTest
+// checks whether the swarm can actually reach the client from outside.
+
+// randomTorrentPort returns a bind address on a random port in the dynamic
+// range, keeping any host part the user configured
+func randomTorrentPort(addr string) string {
+	host := ""
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		host = h
+	}
+	port := 49152 + rand.Intn(65536-49152)
+	return net.JoinHostPort(host, fmt.Sprintf("%d", port))
+}
+
+// PortTestResult broadcast as MsgPortTest so every client sees the outcome
+type PortTestResult struct {
+	Port      int    `json:"port"`
+	Reachable bool   `json:"reachable"`
+	Detail    string `json:"detail,omitempty"`
+}
+
+// ipCheckURL returns this host's public address as plain text
+var ipCheckURL = "https://api.ipify.org"
+
+// testListenPort dials the client's listen port from its public address.
+// Routers without NAT hairpinning can fail this even when the port forwards
+// fine from outside, the detail says so.
+func testListenPort() *PortTestResult {
+	result := &PortTestResult{}
+
+	addr := client.ListenAddr()
+	if addr == nil {
+		result.Detail = "torrent client is not listening"
+		return result
+	}
+	_, portStr, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		result.Detail = err.Error()
+		return result
+	}
+	fmt.Sscanf(portStr, "%d", &result.Port)
+
+	ip, err := publicIP()
+	if err != nil {
+		result.Detail = fmt.Sprintf("failed to find public address: %v", err)
+		return result
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip, portStr), 5*time.Second)
+	if err != nil {
+		result.Detail = fmt.Sprintf("%s:%s is unreachable (note: routers without NAT hairpinning fail this test from inside)", ip, portStr)
+		return result
+	}
+	conn.Close()
+
+	result.Reachable = true
+	result.Detail = fmt.Sprintf("reachable at %s:%s", ip, portStr)
+	return result
+}
+
+func publicIP() (string, error) {
+	resp, err := http.Get(ipCheckURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	buf, err := io.ReadAll(io.LimitReader(resp.Body, 64))
+	if err != nil {
+		return "", err
+	}
+
+	ip := strings.TrimSpace(string(buf))
+	if net.ParseIP(ip) == nil {
+		return "", fmt.Errorf("check service returned %q", ip)
+	}
+	return ip, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	socket "github.com/akillmer/go-socket"
+	db "github.com/akillmer/riptide/database"
+	"github.com/teris-io/shortid"
+)
+
+// Settings profiles bundle the runtime knobs under a name — "day" throttles
+// hard while people use the connection, "night" opens everything up — and
+// switch via the API or on a daily schedule.
+
+// Profile is a named settings bundle, only the fields a profile sets are
+// applied when it activates
+type Profile struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	ServerSettings
+	// QueuePolicy switches the queue ordering along with the settings
+	QueuePolicy *string `json:"queuePolicy,omitempty"`
+	// At activates the profile every day at this HH:MM server time
+	At string `json:"at,omitempty"`
+}
+
+const activeProfileKey = "activeProfile"
+
+// ProfileList is the MsgProfiles response, Active is the ID of the profile
+// that last activated
+type ProfileList struct {
+	Profiles []*Profile `json:"profiles"`
+	Active   string     `json:"active,omitempty"`
+}
+
+// ErrProfileNotFound when a profile ID doesn't exist
+var ErrProfileNotFound = errors.New("profile not found")
+
+// GetProfile by its ID
+func GetProfile(id string) (*Profile, error) {
+	p := &Profile{}
+	buf, err := db.Get(db.BucketProfiles, id)
+	if err != nil {
+		return nil, err
+	}
+	if buf == nil {
+		return nil, ErrProfileNotFound
+	}
+	if err := json.Unmarshal(buf, p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Save this Profile with the database. If it's a new profile then a new short
+// id is assigned.
+func (p *Profile) Save() error {
+	if p.At != "" {
+		if _, err := time.Parse("15:04", p.At); err != nil {
+			return fmt.Errorf("invalid profile time %q, want HH:MM", p.At)
+		}
+	}
+	if p.QueuePolicy != nil && !queuePolicies[*p.QueuePolicy] {
+		return errors.New("unknown queue policy")
+	}
+	if p.ID == "" {
+		id, err := shortid.Generate()
+		if err != nil {
+			return err
+		}
+		p.ID = id
+	}
+	return db.Put(db.BucketProfiles, p.ID, p)
+}
+
+// GetAllProfiles from the database
+func GetAllProfiles() ([]*Profile, error) {
+	var all []*Profile
+	for _, buf := range db.All(db.BucketProfiles) {
+		p := &Profile{}
+		if err := json.Unmarshal(buf, p); err != nil {
+			return nil, err
+		}
+		all = append(all, p)
+	}
+	return all, nil
+}
+
+// activateProfile applies the profile's settings through the same path the
+// settings API uses, remembers it as active, and tells every client
+func activateProfile(id string) error {
+	p, err := GetProfile(id)
+	if err != nil {
+		return err
+	}
+
+	if err := setServerSettings(&p.ServerSettings); err != nil {
+		return err
+	}
+	if p.QueuePolicy != nil {
+		if err := setQueuePolicy(*p.QueuePolicy); err != nil {
+			return err
+		}
+	}
+
+	if err := db.Put(db.BucketSettings, activeProfileKey, p.ID); err != nil {
+		return err
+	}
+	log.Printf("settings profile %q is now active", p.Name)
+	return socket.Broadcast(MsgProfileActive, p.ID)
+}
+
+// getActiveProfile returns the active profile's ID, empty when none was ever
+// activated
+func getActiveProfile() string {
+	buf, _ := db.Get(db.BucketSettings, activeProfileKey)
+	return string(buf)
+}
+
+// watchProfiles activates scheduled profiles when their daily time comes up
+func watchProfiles() {
+	ticker := time.NewTicker(time.Minute)
+	for range ticker.C {
+		all, err := GetAllProfiles()
+		if err != nil {
+			log.Printf("failed to load profiles: %v", err)
+			continue
+		}
+
+		now := time.Now().Format("15:04")
+		for _, p := range all {
+			if p.At == now && p.ID != getActiveProfile() {
+				if err := activateProfile(p.ID); err != nil {
+					log.Printf("failed to activate profile %q: %v", p.Name, err)
+				}
+			}
+		}
+	}
+}
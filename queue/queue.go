@@ -1,7 +1,10 @@
 package queue
 
 import (
+	"encoding/binary"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	db "github.com/akillmer/riptide/database"
@@ -16,6 +19,10 @@ var (
 	activeHashes  = sync.Map{}
 	cForce, cNext chan string
 	cDone         chan struct{}
+	lastTick      int64
+
+	// holdNext, when set, is consulted before each dequeue
+	holdNext atomic.Value
 )
 
 func init() {
@@ -24,11 +31,167 @@ func init() {
 	cForce = make(chan string)
 }
 
+// Seed slots are tracked separately from the download slots Run hands out,
+// so a finished download frees its slot even while the torrent keeps seeding.
+var (
+	seedSlots   = sync.Map{}
+	maxSeeds    int64
+	seedsActive int64
+)
+
+// SetMaxSeeds caps how many torrents may seed at once, independent of the
+// download slots, zero means unlimited
+func SetMaxSeeds(n int) {
+	atomic.StoreInt64(&maxSeeds, int64(n))
+}
+
+// AcquireSeedSlot claims a seed slot for the hash, it reports false when
+// every slot is busy. Holding a slot already always succeeds.
+func AcquireSeedSlot(hash string) bool {
+	if _, ok := seedSlots.Load(hash); ok {
+		return true
+	}
+	if max := atomic.LoadInt64(&maxSeeds); max > 0 && atomic.LoadInt64(&seedsActive) >= max {
+		return false
+	}
+	seedSlots.Store(hash, nil)
+	atomic.AddInt64(&seedsActive, 1)
+	return true
+}
+
+// ReleaseSeedSlot frees the hash's seed slot, if it holds one
+func ReleaseSeedSlot(hash string) {
+	if _, ok := seedSlots.Load(hash); ok {
+		seedSlots.Delete(hash)
+		atomic.AddInt64(&seedsActive, -1)
+	}
+}
+
+// SetHold installs a check consulted before each dequeue. While it reports
+// true the queue stops handing out new hashes but keeps servicing Done and
+// ForceNext, so torrents finishing during a hold never wedge the worker.
+func SetHold(f func() bool) {
+	holdNext.Store(f)
+}
+
+func heldBack() bool {
+	if f, ok := holdNext.Load().(func() bool); ok && f != nil {
+		return f()
+	}
+	return false
+}
+
 // Add a torrent by its hash to the queue
 func Add(hash string) error {
 	return db.Put(db.BucketQueued, db.AutoIncrement, hash)
 }
 
+// AddFront puts a torrent's hash at the front of the queue, so it becomes
+// active as soon as a slot frees up without displacing a running torrent
+func AddFront(hash string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(db.BucketQueued)
+		k, _ := b.Cursor().First()
+		if k == nil {
+			id, _ := b.NextSequence()
+			k = itob(id)
+		} else if first := binary.BigEndian.Uint64(k); first > 0 {
+			k = itob(first - 1)
+		} else {
+			// the queue is already rooted at key zero, join the back instead
+			id, _ := b.NextSequence()
+			k = itob(id)
+		}
+		return b.Put(k, []byte(hash))
+	})
+}
+
+func itob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+// Reorder rewrites the queued hashes in the order decided by less, reusing
+// the existing keys so the queue's place among future Adds doesn't move.
+// The sort is stable, equal entries keep their insertion order.
+func Reorder(less func(a, b string) bool) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(db.BucketQueued)
+
+		var keys [][]byte
+		var hashes []string
+		b.ForEach(func(k, v []byte) error {
+			keys = append(keys, append([]byte(nil), k...))
+			hashes = append(hashes, string(v))
+			return nil
+		})
+		if len(hashes) < 2 {
+			return nil
+		}
+
+		sort.SliceStable(hashes, func(i, j int) bool {
+			return less(hashes[i], hashes[j])
+		})
+
+		for i, k := range keys {
+			if err := b.Put(k, []byte(hashes[i])); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Hashes returns every queued hash in queue order
+func Hashes() ([]string, error) {
+	var hashes []string
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(db.BucketQueued).ForEach(func(k, v []byte) error {
+			hashes = append(hashes, string(v))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
+
+// Prune removes queued entries the keep predicate rejects, along with any
+// duplicate entries for the same hash, and returns what was dropped
+func Prune(keep func(hash string) bool) ([]string, error) {
+	var removed []string
+	err := db.Update(func(tx *bolt.Tx) error {
+		removed = removed[:0]
+		b := tx.Bucket(db.BucketQueued)
+		seen := map[string]bool{}
+
+		var drop [][]byte
+		b.ForEach(func(k, v []byte) error {
+			hash := string(v)
+			if seen[hash] || !keep(hash) {
+				drop = append(drop, append([]byte(nil), k...))
+				removed = append(removed, hash)
+				return nil
+			}
+			seen[hash] = true
+			return nil
+		})
+
+		for _, k := range drop {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
+
 // ForceNext a hash to the front of the queue. Since this immediately means
 // the torrent becomes active it is not stored within the database.
 func ForceNext(hash string) {
@@ -60,6 +223,8 @@ func Run(maxActive int) {
 	numActive := 0
 
 	for {
+		atomic.StoreInt64(&lastTick, time.Now().UnixNano())
+
 		select {
 		case <-cDone:
 			numActive--
@@ -74,7 +239,7 @@ func Run(maxActive int) {
 			break
 		}
 
-		if numActive < maxActive {
+		if numActive < maxActive && !heldBack() {
 			// going to ignore the error here, since we may not always get a value
 			buf, _ := db.Get(db.BucketQueued, db.GetFirstKey)
 			if buf != nil {
@@ -86,6 +251,12 @@ func Run(maxActive int) {
 	}
 }
 
+// LastTick is when the queue worker last went around its loop, used for
+// liveness checks
+func LastTick() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&lastTick))
+}
+
 // Remove a hash from the queue
 func Remove(hash string) error {
 	return db.Update(func(tx *bolt.Tx) error {
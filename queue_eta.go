@@ -0,0 +1,90 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/akillmer/riptide/queue"
+)
+
+// ETA estimates for queued torrents, so a user can see whether the queue
+// will get to something tonight or whether it's worth a ForceNext. The
+// model is deliberately simple: current global throughput split evenly
+// across the active slots, queued torrents claiming slots as the active
+// ones finish, in queue order.
+
+// QueueETA is one queued torrent's estimate. StartsAt and FinishesAt are
+// unix seconds and stay zero when there's no throughput to extrapolate from
+type QueueETA struct {
+	Hash           string `json:"hash"`
+	Position       int    `json:"position"`
+	RemainingBytes int64  `json:"remainingBytes"`
+	StartsAt       int64  `json:"startsAt,omitempty"`
+	FinishesAt     int64  `json:"finishesAt,omitempty"`
+}
+
+// queueETAs walks the queue in order and estimates when each entry starts
+// and finishes downloading
+func queueETAs() ([]*QueueETA, error) {
+	queued, err := queue.Hashes()
+	if err != nil {
+		return nil, err
+	}
+
+	all, err := GetAllTorrentInfo()
+	if err != nil {
+		return nil, err
+	}
+	byHash := make(map[string]*TorrentInfo, len(all))
+
+	// current throughput and time left of everything actively downloading
+	var totalBps int64
+	var activeFinish []float64
+	now := time.Now().Unix()
+
+	for _, info := range all {
+		byHash[info.Hash] = info
+		if info.Status != StatusActive {
+			continue
+		}
+		remaining := info.TotalBytes - info.BytesCompleted
+		if progress, ok := GetLatestProgress(info.Hash); ok {
+			totalBps += progress.BpsDown
+			if r := info.TotalBytes - progress.BytesCompleted; r > 0 {
+				remaining = r
+			}
+		}
+		activeFinish = append(activeFinish, float64(remaining))
+	}
+
+	slots := len(activeFinish)
+	perSlot := float64(0)
+	if slots > 0 && totalBps > 0 {
+		perSlot = float64(totalBps) / float64(slots)
+		for i, remaining := range activeFinish {
+			activeFinish[i] = float64(now) + remaining/perSlot
+		}
+		sort.Float64s(activeFinish)
+	}
+
+	etas := []*QueueETA{}
+	for i, hash := range queued {
+		eta := &QueueETA{Hash: hash, Position: i + 1}
+		if info, ok := byHash[hash]; ok {
+			eta.RemainingBytes = info.TotalBytes - info.BytesCompleted
+		}
+
+		if perSlot > 0 && len(activeFinish) > 0 {
+			// take over the earliest-freeing slot, then hand it on
+			start := activeFinish[0]
+			finish := start + float64(eta.RemainingBytes)/perSlot
+			activeFinish[0] = finish
+			sort.Float64s(activeFinish)
+
+			eta.StartsAt = int64(start)
+			eta.FinishesAt = int64(finish)
+		}
+		etas = append(etas, eta)
+	}
+	return etas, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"log"
+
+	socket "github.com/akillmer/go-socket"
+	db "github.com/akillmer/riptide/database"
+	queue "github.com/akillmer/riptide/queue"
+)
+
+// Queue ordering policies. The default FIFO keeps strict insertion order,
+// the others re-sort the waiting torrents every time one is added.
+const (
+	QueueFIFO         = "FIFO"
+	QueueSmallest     = "SMALLEST"      // smallest download first
+	QueueOldest       = "OLDEST"        // longest-waiting first
+	QueuePriority     = "PRIORITY"      // bandwidth priority class first
+	QueueRatioDeficit = "RATIO_DEFICIT" // furthest below the global ratio first
+	QueueRareFirst    = "RARE_FIRST"    // fewest scraped seeders first
+)
+
+var queuePolicies = map[string]bool{
+	QueueFIFO:         true,
+	QueueSmallest:     true,
+	QueueOldest:       true,
+	QueuePriority:     true,
+	QueueRatioDeficit: true,
+	QueueRareFirst:    true,
+}
+
+const queuePolicyKey = "queuePolicy"
+
+// getQueuePolicy returns the stored policy, FIFO until one is set
+func getQueuePolicy() string {
+	buf, err := db.Get(db.BucketSettings, queuePolicyKey)
+	if err != nil || !queuePolicies[string(buf)] {
+		return QueueFIFO
+	}
+	return string(buf)
+}
+
+// setQueuePolicy stores the policy, re-sorts the queue under it, and lets
+// every client know
+func setQueuePolicy(policy string) error {
+	if !queuePolicies[policy] {
+		return errors.New("unknown queue policy")
+	}
+	if err := db.Put(db.BucketSettings, queuePolicyKey, policy); err != nil {
+		return err
+	}
+	reorderQueue()
+	return socket.Broadcast(MsgQueuePolicy, policy)
+}
+
+// reorderQueue re-sorts the waiting torrents under the current policy, a
+// no-op for FIFO
+func reorderQueue() {
+	policy := getQueuePolicy()
+	if policy == QueueFIFO {
+		return
+	}
+
+	allInfo, err := GetAllTorrentInfo()
+	if err != nil {
+		log.Printf("failed to load torrents for queue reorder: %v", err)
+		return
+	}
+	byHash := make(map[string]*TorrentInfo, len(allInfo))
+	for _, info := range allInfo {
+		byHash[info.Hash] = info
+	}
+
+	err = queue.Reorder(func(a, b string) bool {
+		ia, ib := byHash[a], byHash[b]
+		if ia == nil || ib == nil {
+			return ib == nil && ia != nil
+		}
+		return queueLess(policy, ia, ib)
+	})
+	if err != nil {
+		log.Printf("failed to reorder queue: %v", err)
+	}
+}
+
+func queueLess(policy string, a, b *TorrentInfo) bool {
+	switch policy {
+	case QueueSmallest:
+		// metadata-less torrents report zero bytes, they go last rather
+		// than jumping every known size
+		return sizeOrMax(a.TotalBytes) < sizeOrMax(b.TotalBytes)
+	case QueueOldest:
+		return a.TimeAdded < b.TimeAdded
+	case QueuePriority:
+		return priorityWeightOf(a.Priority) > priorityWeightOf(b.Priority)
+	case QueueRatioDeficit:
+		return ratioDeficit(a) > ratioDeficit(b)
+	case QueueRareFirst:
+		// grab poorly-seeded torrents while their seeders are still around,
+		// torrents that were never scraped wait behind known-rare ones
+		return seedersOrMax(a) < seedersOrMax(b)
+	}
+	return false
+}
+
+func sizeOrMax(size int64) int64 {
+	if size <= 0 {
+		return int64(^uint64(0) >> 1)
+	}
+	return size
+}
+
+func seedersOrMax(info *TorrentInfo) int64 {
+	if info.LastScraped == 0 {
+		return int64(^uint64(0) >> 1)
+	}
+	return int64(info.SwarmSeeders)
+}
+
+func priorityWeightOf(priority string) int64 {
+	if w, ok := priorityWeight[priority]; ok {
+		return w
+	}
+	return priorityWeight[PriorityNormal]
+}
+
+// ratioDeficit is how far the torrent sits below the ratio it owes, the
+// global ratio or a stricter tracker floor, torrents that owe the most
+// seeding sort first
+func ratioDeficit(info *TorrentInfo) float64 {
+	required := globalRatio
+	if req := requirementFor(info); req != nil && req.MinRatio > required {
+		required = req.MinRatio
+	}
+	if required <= 0 || info.TotalBytes <= 0 {
+		return 0
+	}
+	return required - float64(info.BytesUploaded)/float64(info.TotalBytes)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"golang.org/x/time/rate"
+)
+
+// Labels can cap download and upload speed on top of the global -down/-up
+// limits. Every torrent carrying the label shares one limiter, so the cap is
+// collective rather than per torrent.
+
+// labelBurst must fit the largest chunk a peer may request
+const labelBurst = 256 << 10
+
+type labelLimits struct {
+	down *rate.Limiter
+	up   *rate.Limiter
+}
+
+var (
+	labelLimitsMu sync.Mutex
+	labelLimiters = map[string]*labelLimits{}
+)
+
+// labelLimit converts a cap in KiB/s to a rate.Limit, zero means unlimited
+func labelLimit(speed int) rate.Limit {
+	if speed <= 0 {
+		return rate.Inf
+	}
+	return rate.Limit(speed << 10)
+}
+
+// getLabelLimits returns the label's shared limiters, creating them on first
+// use
+func getLabelLimits(lbl *Label) *labelLimits {
+	labelLimitsMu.Lock()
+	defer labelLimitsMu.Unlock()
+
+	lim, ok := labelLimiters[lbl.ID]
+	if !ok {
+		lim = &labelLimits{
+			down: rate.NewLimiter(labelLimit(lbl.MaxDownloadSpeed), labelBurst),
+			up:   rate.NewLimiter(labelLimit(lbl.MaxUploadSpeed), labelBurst),
+		}
+		labelLimiters[lbl.ID] = lim
+	}
+	return lim
+}
+
+// updateLabelLimits adjusts the shared limiters after a label edit, every
+// member torrent picks up the new caps immediately
+func updateLabelLimits(lbl *Label) {
+	labelLimitsMu.Lock()
+	defer labelLimitsMu.Unlock()
+
+	if lim, ok := labelLimiters[lbl.ID]; ok {
+		lim.down.SetLimit(labelLimit(lbl.MaxDownloadSpeed))
+		lim.up.SetLimit(labelLimit(lbl.MaxUploadSpeed))
+	}
+}
+
+// dropLabelLimits forgets a deleted label's limiters
+func dropLabelLimits(id string) {
+	labelLimitsMu.Lock()
+	defer labelLimitsMu.Unlock()
+	delete(labelLimiters, id)
+}
+
+// applyLabelLimits points the torrent at its label's shared limiters, or
+// removes them when the torrent has no label. It's a no-op while the client
+// doesn't hold the torrent, startTorrent re-applies on the way up.
+func applyLabelLimits(hash, labelID string) {
+	t, ok := client.Torrent(metainfo.NewHashFromHex(hash))
+	if !ok {
+		return
+	}
+
+	if labelID == "" {
+		t.SetRateLimiters(nil, nil)
+		return
+	}
+
+	lbl, err := GetLabel(labelID)
+	if err != nil {
+		t.SetRateLimiters(nil, nil)
+		return
+	}
+
+	lim := getLabelLimits(lbl)
+	t.SetRateLimiters(lim.down, lim.up)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/akillmer/riptide/queue"
+)
+
+// After a VPN reconnect the client keeps announcing from its old address and
+// the swarm goes stale. MsgServerReconnect bounces every running torrent
+// (which drops and re-adds it, re-announcing to its trackers and DHT from the
+// new address) and re-bootstraps the DHT routing table, reporting progress
+// through the activity feed.
+
+// reconnecting guards against overlapping reconnect runs
+var reconnecting int32
+
+// ErrReconnectRunning means a reconnect was requested while one is underway
+var ErrReconnectRunning = errors.New("a reconnect is already in progress")
+
+// serverReconnect kicks off the reconnect in the background, the caller gets
+// an immediate OK and watches the activity feed for progress
+func serverReconnect() error {
+	if !atomic.CompareAndSwapInt32(&reconnecting, 0, 1) {
+		return ErrReconnectRunning
+	}
+	go reconnectSwarm()
+	return nil
+}
+
+func reconnectSwarm() {
+	defer atomic.StoreInt32(&reconnecting, 0)
+
+	appendActivity("", "", "reconnect", "refreshing swarm connections")
+
+	// re-bootstrap the DHT first so restarted torrents announce into a
+	// refreshed routing table instead of one full of pre-reconnect nodes
+	if s := client.DHT(); s != nil {
+		if stats, err := s.Bootstrap(); err != nil {
+			log.Printf("dht bootstrap failed: %v", err)
+			appendActivity("", "", "reconnect", fmt.Sprintf("DHT bootstrap failed: %v", err))
+			fireWebhook("dht.bootstrap_failed", "", err.Error())
+		} else {
+			appendActivity("", "", "reconnect",
+				fmt.Sprintf("DHT bootstrap got %d responses from %d queries", stats.NumResponses, stats.NumAddrsTried))
+			fireWebhook("dht.bootstrapped", "", fmt.Sprintf("%d responses", stats.NumResponses))
+		}
+	}
+
+	var running []string
+	managedTorrents.Range(func(k, v interface{}) bool {
+		running = append(running, k.(string))
+		return true
+	})
+
+	for _, hash := range running {
+		stopTorrent(hash)
+	}
+
+	// wait for each manager to drop its torrent from the client before
+	// forcing it back, otherwise the restart races the old manager's close
+	for _, hash := range running {
+		for i := 0; i < 50; i++ {
+			if _, ok := managedTorrents.Load(hash); !ok {
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		queue.ForceNext(hash)
+	}
+
+	appendActivity("", "", "reconnect", fmt.Sprintf("restarted %d torrents", len(running)))
+	log.Printf("reconnect complete, restarted %d torrents", len(running))
+}
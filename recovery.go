@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	db "github.com/akillmer/riptide/database"
+	"github.com/akillmer/riptide/queue"
+	"github.com/boltdb/bolt"
+)
+
+// One bad JSON blob used to make the whole server unbootable, startup now
+// quarantines records that no longer decode into BucketCorrupt and keeps
+// going. The raw bytes are preserved for manual repair and the quarantined
+// set is queryable over the API.
+
+// CorruptRecord is a database record that failed to decode at startup
+type CorruptRecord struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+	Error  string `json:"error"`
+	// Raw holds the record's original bytes, untouched
+	Raw  string `json:"raw"`
+	Time int64  `json:"time"`
+}
+
+// quarantineCorruptTorrents moves every undecodable torrent record out of
+// BucketTorrents so the rest of startup only sees good ones
+func quarantineCorruptTorrents() {
+	type bad struct {
+		key string
+		raw []byte
+		err string
+	}
+	var bads []bad
+
+	db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(db.BucketTorrents).ForEach(func(k, v []byte) error {
+			info := &TorrentInfo{}
+			if err := json.Unmarshal(v, info); err != nil {
+				bads = append(bads, bad{string(k), append([]byte(nil), v...), err.Error()})
+			}
+			return nil
+		})
+	})
+
+	for _, b := range bads {
+		rec := &CorruptRecord{
+			Bucket: string(db.BucketTorrents),
+			Key:    b.key,
+			Error:  b.err,
+			Raw:    string(b.raw),
+			Time:   time.Now().Unix(),
+		}
+		if err := db.Put(db.BucketCorrupt, b.key, rec); err != nil {
+			log.Printf("failed to quarantine corrupt record %s: %v", b.key, err)
+			continue
+		}
+		db.Delete(db.BucketTorrents, b.key)
+		log.Printf("quarantined corrupt torrent record %s: %s", b.key, b.err)
+	}
+
+	if len(bads) > 0 {
+		log.Printf("%d corrupt records quarantined, inspect them via %s", len(bads), MsgCorruptRecords)
+	}
+}
+
+// QueueRepairReport lists what a reconciliation pass fixed
+type QueueRepairReport struct {
+	// Removed are queue entries whose torrent record no longer exists,
+	// plus duplicate entries for the same hash
+	Removed []string `json:"removed"`
+	// Requeued are torrents stuck in StatusQueued without a queue entry
+	Requeued []string `json:"requeued"`
+}
+
+// repairQueue reconciles BucketQueued against the torrent records, the two
+// can drift apart when records are deleted outside the normal flow
+func repairQueue() (*QueueRepairReport, error) {
+	report := &QueueRepairReport{Removed: []string{}, Requeued: []string{}}
+
+	allInfo, err := GetAllTorrentInfo()
+	if err != nil {
+		return nil, err
+	}
+	byHash := make(map[string]*TorrentInfo, len(allInfo))
+	for _, info := range allInfo {
+		byHash[info.Hash] = info
+	}
+
+	removed, err := queue.Prune(func(hash string) bool {
+		_, ok := byHash[hash]
+		return ok
+	})
+	if err != nil {
+		return nil, err
+	}
+	report.Removed = append(report.Removed, removed...)
+
+	queued, err := queue.Hashes()
+	if err != nil {
+		return nil, err
+	}
+	inQueue := make(map[string]bool, len(queued))
+	for _, hash := range queued {
+		inQueue[hash] = true
+	}
+
+	for _, info := range allInfo {
+		if info.Status != StatusQueued || inQueue[info.Hash] {
+			continue
+		}
+		if _, running := managedTorrents.Load(info.Hash); running {
+			continue
+		}
+		if err := queue.Add(info.Hash); err != nil {
+			return nil, err
+		}
+		report.Requeued = append(report.Requeued, info.Hash)
+	}
+	if len(report.Requeued) > 0 {
+		reorderQueue()
+	}
+
+	for _, hash := range report.Removed {
+		log.Printf("queue repair: removed orphaned entry %s", hash)
+	}
+	for _, hash := range report.Requeued {
+		log.Printf("queue repair: requeued stranded torrent %s", hash)
+	}
+	return report, nil
+}
+
+// getCorruptRecords returns everything in quarantine
+func getCorruptRecords() []*CorruptRecord {
+	all := []*CorruptRecord{}
+	for _, buf := range db.All(db.BucketCorrupt) {
+		rec := &CorruptRecord{}
+		if err := json.Unmarshal(buf, rec); err != nil {
+			continue
+		}
+		all = append(all, rec)
+	}
+	return all
+}
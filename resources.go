@@ -0,0 +1,102 @@
+package main
+
+import (
+	"log"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// Resource guard for small hosts. Every active torrent holds file descriptors
+// (payload files plus a socket per peer) and buffer memory, and a box that
+// runs out of either fails in ugly ways. When usage approaches the configured
+// limits the guard holds back new activations until it drops again; torrents
+// that are already running are left alone.
+
+var (
+	maxOpenFDs int // activation hold thresholds, 0 means disabled
+	maxMemMB   int
+
+	// resourceHold is set while usage is over a limit, the queue worker
+	// consults it before dequeuing the next torrent
+	resourceHold int32
+)
+
+// countOpenFDs returns the process's open descriptor count, or -1 where
+// /proc isn't available
+func countOpenFDs() int {
+	dir, err := os.Open("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	defer dir.Close()
+
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return -1
+	}
+	return len(names)
+}
+
+// heapMB is the live heap in megabytes
+func heapMB() int {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return int(ms.HeapAlloc >> 20)
+}
+
+// overResourceLimit reports whether either configured threshold is crossed
+// along with the readings that went into the decision
+func overResourceLimit() (over bool, fds, mem int) {
+	fds = countOpenFDs()
+	mem = heapMB()
+	if maxOpenFDs > 0 && fds >= maxOpenFDs {
+		over = true
+	}
+	if maxMemMB > 0 && mem >= maxMemMB {
+		over = true
+	}
+	return
+}
+
+// watchResources polls descriptor and heap usage and toggles the activation
+// hold. The hold releases only once usage falls below 90% of the limit so a
+// torrent sitting right at the threshold doesn't flap it
+func watchResources() {
+	if maxOpenFDs == 0 && maxMemMB == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(10 * time.Second)
+	for range ticker.C {
+		over, fds, mem := overResourceLimit()
+
+		if over && atomic.CompareAndSwapInt32(&resourceHold, 0, 1) {
+			log.Printf("resource limit reached: fds=%d fd-limit=%d heap-mb=%d mem-limit-mb=%d action=hold-activations",
+				fds, maxOpenFDs, mem, maxMemMB)
+			continue
+		}
+
+		if atomic.LoadInt32(&resourceHold) == 1 {
+			clear := true
+			if maxOpenFDs > 0 && fds > maxOpenFDs*9/10 {
+				clear = false
+			}
+			if maxMemMB > 0 && mem > maxMemMB*9/10 {
+				clear = false
+			}
+			if clear && atomic.CompareAndSwapInt32(&resourceHold, 1, 0) {
+				log.Printf("resource usage recovered: fds=%d heap-mb=%d action=resume-activations", fds, mem)
+			}
+		}
+	}
+}
+
+// resourceHoldActive reports whether activations are held, installed into
+// the queue with SetHold so the hold is applied before a hash is dequeued.
+// Blocking after Next() instead would stall the queue worker itself, leaving
+// Done and ForceNext unserviced and deadlocking finishing torrents.
+func resourceHoldActive() bool {
+	return atomic.LoadInt32(&resourceHold) == 1
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/akillmer/riptide/queue"
+)
+
+// Transient failures — tracker timeouts, a database hiccup, the client
+// dropping a torrent — retry themselves with exponential backoff instead of
+// sitting in StatusError until someone notices. Permanent conditions like
+// missing data stay put for the user.
+
+const (
+	maxErrorRetries = 5
+	retryBaseDelay  = time.Minute // doubles with every attempt
+)
+
+// transientError reports whether a failure is worth retrying automatically,
+// reasons that need a human stay in StatusError
+func transientError(reason string) bool {
+	switch reason {
+	case "data missing", "verification failed":
+		return false
+	}
+	return true
+}
+
+// scheduleRetry arms an automatic retry for a transient failure. Once the
+// retry budget is spent the torrent settles into StatusError for good.
+func scheduleRetry(hash, reason string) {
+	if !transientError(reason) {
+		return
+	}
+
+	UpdateTorrent(hash, func(info *TorrentInfo) error {
+		if info.RetryCount >= maxErrorRetries {
+			info.NextRetry = 0
+			return nil
+		}
+		info.RetryCount++
+		delay := retryBaseDelay << uint(info.RetryCount-1)
+		info.NextRetry = time.Now().Add(delay).Unix()
+		appendHistory(hash, "retry scheduled", fmt.Sprintf("attempt %d of %d in %s",
+			info.RetryCount, maxErrorRetries, delay))
+		return nil
+	})
+}
+
+// watchRetries requeues errored torrents whose backoff has elapsed
+func watchRetries() {
+	ticker := time.NewTicker(30 * time.Second)
+	for range ticker.C {
+		all, err := GetAllTorrentInfo()
+		if err != nil {
+			log.Printf("failed to load torrents for retries: %v", err)
+			continue
+		}
+
+		now := time.Now().Unix()
+		for _, info := range all {
+			if info.Status != StatusError || info.NextRetry == 0 || info.NextRetry > now {
+				continue
+			}
+			if _, running := managedTorrents.Load(info.Hash); running {
+				continue
+			}
+
+			hash := info.Hash
+			err := UpdateTorrent(hash, func(info *TorrentInfo) error {
+				info.Status = StatusQueued
+				info.Error = ""
+				info.NextRetry = 0
+				return nil
+			})
+			if err != nil {
+				log.Printf("failed to requeue %s for retry: %v", hash, err)
+				continue
+			}
+			if err := queue.Add(hash); err != nil {
+				log.Printf("failed to queue %s for retry: %v", hash, err)
+				continue
+			}
+			reorderQueue()
+		}
+	}
+}
+
+// retryTorrent is the manual retry command, it resets the backoff state and
+// requeues the torrent right away
+func retryTorrent(hash string) error {
+	err := UpdateTorrent(hash, func(info *TorrentInfo) error {
+		if info.Status != StatusError {
+			return errors.New("torrent is not in an error state")
+		}
+		info.Status = StatusQueued
+		info.Error = ""
+		info.RetryCount = 0
+		info.NextRetry = 0
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := queue.Add(hash); err != nil {
+		return err
+	}
+	reorderQueue()
+	return nil
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// A small expression language for scripted rules, just enough to ask
+// questions about one torrent without embedding an interpreter:
+//
+//	name matches "(?i)linux.*iso" and size > 4gb and not label == "keep"
+//
+// Comparisons are field OP value with ==, !=, <, <=, >, >=, matches
+// (regex), and contains (case-insensitive substring), joined by and/or/not
+// with parentheses. Sizes take kb/mb/gb/tb suffixes. Expressions compile at
+// save time so a typo fails the API call, not the event.
+
+// fieldFn resolves a field name to its value: a string, float64, or bool
+type fieldFn func(name string) (interface{}, bool)
+
+// ruleExpr is one compiled expression node
+type ruleExpr interface {
+	eval(get fieldFn) (bool, error)
+}
+
+type boolExpr struct {
+	op   string // "and" or "or"
+	l, r ruleExpr
+}
+
+func (e *boolExpr) eval(get fieldFn) (bool, error) {
+	l, err := e.l.eval(get)
+	if err != nil {
+		return false, err
+	}
+	if e.op == "and" && !l {
+		return false, nil
+	}
+	if e.op == "or" && l {
+		return true, nil
+	}
+	return e.r.eval(get)
+}
+
+type notRuleExpr struct {
+	e ruleExpr
+}
+
+func (e *notRuleExpr) eval(get fieldFn) (bool, error) {
+	ok, err := e.e.eval(get)
+	return !ok, err
+}
+
+type cmpExpr struct {
+	field string
+	op    string
+	str   string
+	num   float64
+	isNum bool
+	re    *regexp.Regexp
+}
+
+func (e *cmpExpr) eval(get fieldFn) (bool, error) {
+	v, ok := get(e.field)
+	if !ok {
+		return false, fmt.Errorf("unknown field %q", e.field)
+	}
+
+	switch e.op {
+	case "matches":
+		s, ok := v.(string)
+		if !ok {
+			return false, fmt.Errorf("field %q is not text", e.field)
+		}
+		return e.re.MatchString(s), nil
+
+	case "contains":
+		s, ok := v.(string)
+		if !ok {
+			return false, fmt.Errorf("field %q is not text", e.field)
+		}
+		return strings.Contains(strings.ToLower(s), strings.ToLower(e.str)), nil
+
+	case "==", "!=":
+		eq, err := e.equals(v)
+		if err != nil {
+			return false, err
+		}
+		if e.op == "!=" {
+			eq = !eq
+		}
+		return eq, nil
+
+	default: // <, <=, >, >=
+		n, ok := v.(float64)
+		if !ok {
+			return false, fmt.Errorf("field %q is not a number", e.field)
+		}
+		if !e.isNum {
+			return false, fmt.Errorf("field %q compares against a number", e.field)
+		}
+		switch e.op {
+		case "<":
+			return n < e.num, nil
+		case "<=":
+			return n <= e.num, nil
+		case ">":
+			return n > e.num, nil
+		}
+		return n >= e.num, nil
+	}
+}
+
+func (e *cmpExpr) equals(v interface{}) (bool, error) {
+	switch v := v.(type) {
+	case string:
+		if e.isNum {
+			return false, fmt.Errorf("field %q is text, not a number", e.field)
+		}
+		return strings.EqualFold(v, e.str), nil
+	case float64:
+		if !e.isNum {
+			return false, fmt.Errorf("field %q is a number, not text", e.field)
+		}
+		return v == e.num, nil
+	case bool:
+		return v == (e.str == "true"), nil
+	}
+	return false, fmt.Errorf("field %q has no comparable value", e.field)
+}
+
+// exprToken kinds
+const (
+	tokIdent = iota
+	tokStr
+	tokNum
+	tokOp
+	tokLParen
+	tokRParen
+)
+
+type exprToken struct {
+	kind int
+	text string
+	num  float64
+}
+
+// sizeSuffixes scale a number literal, so "4gb" reads naturally
+var sizeSuffixes = map[string]float64{
+	"kb": 1 << 10,
+	"mb": 1 << 20,
+	"gb": 1 << 30,
+	"tb": 1 << 40,
+}
+
+func lexRuleExpr(src string) ([]exprToken, error) {
+	var toks []exprToken
+	runes := []rune(src)
+
+	for i := 0; i < len(runes); {
+		c := runes[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+
+		case c == '(':
+			toks = append(toks, exprToken{kind: tokLParen})
+			i++
+		case c == ')':
+			toks = append(toks, exprToken{kind: tokRParen})
+			i++
+
+		case c == '"':
+			j := i + 1
+			for j < len(runes) && runes[j] != '"' {
+				j++
+			}
+			if j == len(runes) {
+				return nil, errors.New("unterminated string")
+			}
+			toks = append(toks, exprToken{kind: tokStr, text: string(runes[i+1 : j])})
+			i = j + 1
+
+		case strings.ContainsRune("=!<>", c):
+			j := i + 1
+			if j < len(runes) && runes[j] == '=' {
+				j++
+			}
+			op := string(runes[i:j])
+			switch op {
+			case "==", "!=", "<", "<=", ">", ">=":
+			default:
+				return nil, fmt.Errorf("bad operator %q", op)
+			}
+			toks = append(toks, exprToken{kind: tokOp, text: op})
+			i = j
+
+		case unicode.IsDigit(c):
+			j := i
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.') {
+				j++
+			}
+			n, err := strconv.ParseFloat(string(runes[i:j]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("bad number %q", string(runes[i:j]))
+			}
+			// a trailing word is a size suffix
+			k := j
+			for k < len(runes) && unicode.IsLetter(runes[k]) {
+				k++
+			}
+			if k > j {
+				scale, ok := sizeSuffixes[strings.ToLower(string(runes[j:k]))]
+				if !ok {
+					return nil, fmt.Errorf("bad size suffix %q", string(runes[j:k]))
+				}
+				n *= scale
+				j = k
+			}
+			toks = append(toks, exprToken{kind: tokNum, num: n})
+			i = j
+
+		case unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_') {
+				j++
+			}
+			toks = append(toks, exprToken{kind: tokIdent, text: string(runes[i:j])})
+			i = j
+
+		default:
+			return nil, fmt.Errorf("unexpected character %q", string(c))
+		}
+	}
+
+	return toks, nil
+}
+
+// exprCache holds compiled expressions by source text, so rules evaluated
+// on every torrent event don't re-lex and re-parse each time. Entries are
+// only ever the expressions of stored rules, so the map stays tiny; a stale
+// entry after a rule edit just lingers unused.
+var exprCache = sync.Map{}
+
+// compileRuleExpr returns the cached compilation of src, parsing it on the
+// first sight. Compiled expressions are immutable and safe to share.
+func compileRuleExpr(src string) (ruleExpr, error) {
+	if e, ok := exprCache.Load(src); ok {
+		return e.(ruleExpr), nil
+	}
+	e, err := parseRuleExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	exprCache.Store(src, e)
+	return e, nil
+}
+
+// exprParser is a recursive-descent parser over the token stream
+type exprParser struct {
+	toks []exprToken
+	pos  int
+}
+
+// parseRuleExpr compiles an expression, an empty source matches everything
+func parseRuleExpr(src string) (ruleExpr, error) {
+	toks, err := lexRuleExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	if len(toks) == 0 {
+		return nil, errors.New("empty expression")
+	}
+
+	p := &exprParser{toks: toks}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.toks) {
+		return nil, errors.New("trailing tokens after expression")
+	}
+	return expr, nil
+}
+
+func (p *exprParser) peek() *exprToken {
+	if p.pos < len(p.toks) {
+		return &p.toks[p.pos]
+	}
+	return nil
+}
+
+func (p *exprParser) parseOr() (ruleExpr, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for t := p.peek(); t != nil && t.kind == tokIdent && t.text == "or"; t = p.peek() {
+		p.pos++
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = &boolExpr{op: "or", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *exprParser) parseAnd() (ruleExpr, error) {
+	l, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for t := p.peek(); t != nil && t.kind == tokIdent && t.text == "and"; t = p.peek() {
+		p.pos++
+		r, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l = &boolExpr{op: "and", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *exprParser) parseNot() (ruleExpr, error) {
+	if t := p.peek(); t != nil && t.kind == tokIdent && t.text == "not" {
+		p.pos++
+		e, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notRuleExpr{e: e}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (ruleExpr, error) {
+	t := p.peek()
+	if t == nil {
+		return nil, errors.New("expression ends early")
+	}
+
+	if t.kind == tokLParen {
+		p.pos++
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.peek(); t == nil || t.kind != tokRParen {
+			return nil, errors.New("missing closing parenthesis")
+		}
+		p.pos++
+		return e, nil
+	}
+
+	if t.kind != tokIdent {
+		return nil, errors.New("expected a field name")
+	}
+	cmp := &cmpExpr{field: t.text}
+	p.pos++
+
+	t = p.peek()
+	if t == nil || (t.kind != tokOp && !(t.kind == tokIdent && (t.text == "matches" || t.text == "contains"))) {
+		return nil, fmt.Errorf("field %q needs a comparison", cmp.field)
+	}
+	cmp.op = t.text
+	p.pos++
+
+	t = p.peek()
+	if t == nil {
+		return nil, fmt.Errorf("comparison on %q is missing its value", cmp.field)
+	}
+	switch t.kind {
+	case tokStr:
+		cmp.str = t.text
+	case tokNum:
+		cmp.num = t.num
+		cmp.isNum = true
+	case tokIdent:
+		if t.text != "true" && t.text != "false" {
+			return nil, fmt.Errorf("bad value %q", t.text)
+		}
+		cmp.str = t.text
+	default:
+		return nil, fmt.Errorf("bad value for comparison on %q", cmp.field)
+	}
+	p.pos++
+
+	if cmp.op == "matches" {
+		if cmp.isNum {
+			return nil, errors.New("matches needs a quoted pattern")
+		}
+		re, err := regexp.Compile(cmp.str)
+		if err != nil {
+			return nil, err
+		}
+		cmp.re = re
+	}
+	if cmp.op == "contains" && cmp.isNum {
+		return nil, errors.New("contains needs a quoted string")
+	}
+
+	return cmp, nil
+}
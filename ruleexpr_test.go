@@ -0,0 +1,128 @@
+package main
+
+import "testing"
+
+// exprTestFields stands in for a torrent during eval tests
+func exprTestFields(name string) (interface{}, bool) {
+	switch name {
+	case "name":
+		return "Linux Distro ISO", true
+	case "label":
+		return "keep", true
+	case "size":
+		return float64(5 << 30), true
+	case "private":
+		return true, true
+	}
+	return nil, false
+}
+
+func TestParseRuleExprErrors(t *testing.T) {
+	bad := []struct {
+		src  string
+		desc string
+	}{
+		{``, "empty expression"},
+		{`name == "abc`, "unterminated string"},
+		{`size => 3`, "bad operator"},
+		{`size > 4xb`, "bad size suffix"},
+		{`size > 4.2.1`, "bad number"},
+		{`name == "a" "b"`, "trailing tokens"},
+		{`name ==`, "missing value"},
+		{`name == banana`, "bare word value"},
+		{`name`, "field without comparison"},
+		{`(name == "a"`, "missing closing parenthesis"},
+		{`5 > size`, "value before field"},
+		{`name matches 3`, "matches with a number"},
+		{`name contains 3`, "contains with a number"},
+		{`name matches "("`, "bad regex"},
+		{`name @ "a"`, "unexpected character"},
+		{`name == "a" and`, "dangling and"},
+	}
+
+	for _, tt := range bad {
+		if _, err := parseRuleExpr(tt.src); err == nil {
+			t.Errorf("%s: %q should not compile", tt.desc, tt.src)
+		}
+	}
+}
+
+func TestRuleExprEval(t *testing.T) {
+	tests := []struct {
+		src     string
+		want    bool
+		wantErr bool
+	}{
+		// comparisons
+		{`name contains "distro"`, true, false},
+		{`name contains "windows"`, false, false},
+		{`name matches "(?i)linux.*iso"`, true, false},
+		{`label == "KEEP"`, true, false}, // string equality ignores case
+		{`label != "keep"`, false, false},
+		{`private == true`, true, false},
+		{`private != true`, false, false},
+
+		// size suffixes scale the literal
+		{`size > 4gb`, true, false},
+		{`size < 4gb`, false, false},
+		{`size >= 5gb`, true, false},
+		{`size <= 5242880kb`, true, false},
+
+		// not binds tighter than and, and tighter than or
+		{`size > 4gb and name contains "nope" or private == true`, true, false},
+		{`size > 4gb and (name contains "nope" or private == true)`, true, false},
+		{`private == true and name contains "nope" or label == "nope"`, false, false},
+		{`not label == "keep"`, false, false},
+		{`not label == "keep" or name contains "iso"`, true, false},
+
+		// type mismatches and unknown fields error out
+		{`name > 3`, false, true},
+		{`size contains "big"`, false, true},
+		{`size matches "big"`, false, true},
+		{`size == "big"`, false, true},
+		{`name == 3`, false, true},
+		{`bogus == 1`, false, true},
+	}
+
+	for _, tt := range tests {
+		expr, err := parseRuleExpr(tt.src)
+		if err != nil {
+			t.Errorf("%q should compile: %v", tt.src, err)
+			continue
+		}
+
+		got, err := expr.eval(exprTestFields)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%q should error at eval time", tt.src)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q should eval: %v", tt.src, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q should eval to %v", tt.src, tt.want)
+		}
+	}
+}
+
+func TestCompileRuleExprCache(t *testing.T) {
+	src := `name contains "cache me"`
+	first, err := compileRuleExpr(src)
+	if err != nil {
+		t.Fatalf("%q should compile: %v", src, err)
+	}
+	second, err := compileRuleExpr(src)
+	if err != nil {
+		t.Fatalf("%q should compile from cache: %v", src, err)
+	}
+	if first != second {
+		t.Fatalf("compiling %q twice should reuse the cached expression", src)
+	}
+
+	if _, err := compileRuleExpr(`name == "broken`); err == nil {
+		t.Fatalf("a bad expression should not compile through the cache")
+	}
+}
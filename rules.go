@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"regexp"
+
+	db "github.com/akillmer/riptide/database"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/teris-io/shortid"
+)
+
+// Rule automatically configures a torrent the moment it's added, whatever
+// path it arrives by. The first matching rule wins.
+type Rule struct {
+	ID string `json:"id"`
+	// NamePattern is a regex matched against the torrent's name
+	NamePattern string `json:"namePattern,omitempty"`
+	// TrackerPattern is a regex matched against the magnet's tracker hosts
+	TrackerPattern string `json:"trackerPattern,omitempty"`
+	// LabelID, MoveTo, and Priority are applied to matching torrents
+	LabelID  string `json:"labelID,omitempty"`
+	MoveTo   string `json:"moveTo,omitempty"`
+	Priority string `json:"priority,omitempty"`
+}
+
+// Validate the rule's patterns compile and that it actually does something
+func (r *Rule) Validate() error {
+	if r.NamePattern == "" && r.TrackerPattern == "" {
+		return errors.New("rule needs a name or tracker pattern")
+	}
+	if r.NamePattern != "" {
+		if _, err := regexp.Compile(r.NamePattern); err != nil {
+			return err
+		}
+	}
+	if r.TrackerPattern != "" {
+		if _, err := regexp.Compile(r.TrackerPattern); err != nil {
+			return err
+		}
+	}
+	if r.LabelID == "" && r.MoveTo == "" && r.Priority == "" {
+		return errors.New("rule assigns nothing")
+	}
+	if r.Priority != "" && !validPriority(r.Priority) {
+		return errors.New("rule has an invalid priority")
+	}
+	return nil
+}
+
+// matches the torrent when every set pattern matches
+func (r *Rule) matches(info *TorrentInfo) bool {
+	if r.NamePattern != "" {
+		if ok, err := regexp.MatchString(r.NamePattern, info.Name); err != nil || !ok {
+			return false
+		}
+	}
+
+	if r.TrackerPattern != "" {
+		magnet, err := metainfo.ParseMagnetURI(info.Magnet)
+		if err != nil {
+			return false
+		}
+		matched := false
+		for _, tr := range magnet.Trackers {
+			if ok, err := regexp.MatchString(r.TrackerPattern, tr); err == nil && ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+// GetAllRules from the database
+func GetAllRules() ([]*Rule, error) {
+	buf := db.All(db.BucketRules)
+	if buf == nil {
+		return nil, nil
+	}
+
+	all := make([]*Rule, len(buf))
+	for i, b := range buf {
+		rule := &Rule{}
+		if err := json.Unmarshal(b, rule); err != nil {
+			return nil, err
+		}
+		all[i] = rule
+	}
+
+	return all, nil
+}
+
+// Save this Rule with the database. If it's a new rule then a new short id
+// is assigned.
+func (r *Rule) Save() error {
+	if r.ID == "" {
+		id, err := shortid.Generate()
+		if err != nil {
+			return err
+		}
+		r.ID = id
+	}
+	return db.Put(db.BucketRules, r.ID, r)
+}
+
+// DeleteRule from the database.
+func DeleteRule(id string) error {
+	return db.Delete(db.BucketRules, id)
+}
+
+// applyRules configures a newly added torrent from the first matching rule
+func applyRules(info *TorrentInfo) {
+	rules, err := GetAllRules()
+	if err != nil {
+		log.Printf("failed to load rules: %v", err)
+		return
+	}
+
+	for _, rule := range rules {
+		if !rule.matches(info) {
+			continue
+		}
+
+		if rule.LabelID != "" {
+			if _, err := GetLabel(rule.LabelID); err == nil {
+				info.LabelID = rule.LabelID
+			}
+		}
+		if rule.MoveTo != "" {
+			info.MoveTo = rule.MoveTo
+		}
+		if rule.Priority != "" {
+			info.Priority = rule.Priority
+		}
+
+		return
+	}
+}
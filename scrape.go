@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Tracker scraping keeps per-torrent swarm counts fresh so the UI can show
+// seeders/leechers and the RARE_FIRST queue policy can put poorly-seeded
+// torrents ahead of well-covered ones.
+
+var scrapeMins int // how often to scrape trackers, 0 disables
+
+// ScrapeResult is one tracker's view of a torrent's swarm
+type ScrapeResult struct {
+	Seeders   int32
+	Leechers  int32
+	Completed int32
+}
+
+// watchScrapes refreshes swarm counts for every stored torrent that isn't
+// stopped, spacing requests out so trackers don't see a thundering herd
+func watchScrapes() {
+	if scrapeMins <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(scrapeMins) * time.Minute)
+	for range ticker.C {
+		all, err := GetAllTorrentInfo()
+		if err != nil {
+			log.Printf("scrape: %v", err)
+			continue
+		}
+
+		for _, info := range all {
+			if info.Status == StatusStopped || info.Status == StatusError {
+				continue
+			}
+			res, err := scrapeTorrent(info)
+			if err != nil {
+				continue // trackers come and go, try again next round
+			}
+			UpdateTorrent(info.Hash, func(info *TorrentInfo) error {
+				info.SwarmSeeders = res.Seeders
+				info.SwarmLeechers = res.Leechers
+				info.SwarmCompleted = res.Completed
+				info.LastScraped = time.Now().Unix()
+				return nil
+			})
+			time.Sleep(time.Second)
+		}
+	}
+}
+
+// scrapeTorrent asks the torrent's trackers in order and returns the first
+// answer, most torrents only have one tracker that matters
+func scrapeTorrent(info *TorrentInfo) (*ScrapeResult, error) {
+	m, err := metainfo.ParseMagnetURI(info.Magnet)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := metainfo.NewHashFromHex(info.Hash)
+	var lastErr error
+	for _, tr := range m.Trackers {
+		res, err := scrapeTracker(tr, hash)
+		if err == nil {
+			return res, nil
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("torrent has no trackers")
+	}
+	return nil, lastErr
+}
+
+func scrapeTracker(trackerURL string, hash metainfo.Hash) (*ScrapeResult, error) {
+	u, err := url.Parse(trackerURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		return scrapeHTTP(u, hash)
+	case "udp":
+		return scrapeUDP(u, hash)
+	}
+	return nil, fmt.Errorf("unsupported tracker scheme %q", u.Scheme)
+}
+
+// scrapeHTTP uses the scrape convention: the last "announce" path segment
+// becomes "scrape", trackers without it don't support scraping
+func scrapeHTTP(u *url.URL, hash metainfo.Hash) (*ScrapeResult, error) {
+	idx := strings.LastIndex(u.Path, "announce")
+	if idx == -1 {
+		return nil, fmt.Errorf("tracker %s does not support scraping", u.Host)
+	}
+	su := *u
+	su.Path = u.Path[:idx] + "scrape" + u.Path[idx+len("announce"):]
+
+	q := su.Query()
+	q.Set("info_hash", string(hash[:]))
+	su.RawQuery = q.Encode()
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(su.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Files map[string]struct {
+			Complete   int32 `bencode:"complete"`
+			Downloaded int32 `bencode:"downloaded"`
+			Incomplete int32 `bencode:"incomplete"`
+		} `bencode:"files"`
+	}
+	if err := bencode.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+
+	f, ok := body.Files[string(hash[:])]
+	if !ok {
+		return nil, fmt.Errorf("tracker %s has no stats for this torrent", u.Host)
+	}
+	return &ScrapeResult{Seeders: f.Complete, Leechers: f.Incomplete, Completed: f.Downloaded}, nil
+}
+
+// BEP 15 protocol constants for the UDP scrape exchange
+const (
+	udpConnectMagic  = 0x41727101980
+	udpActionConnect = 0
+	udpActionScrape  = 2
+)
+
+// scrapeUDP runs the two-step BEP 15 exchange: a connect handshake for a
+// connection ID, then the scrape itself
+func scrapeUDP(u *url.URL, hash metainfo.Hash) (*ScrapeResult, error) {
+	conn, err := net.DialTimeout("udp", u.Host, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	// connect
+	txID := rand.Int31()
+	req := &bytes.Buffer{}
+	binary.Write(req, binary.BigEndian, int64(udpConnectMagic))
+	binary.Write(req, binary.BigEndian, int32(udpActionConnect))
+	binary.Write(req, binary.BigEndian, txID)
+	if _, err := conn.Write(req.Bytes()); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 16)
+	if _, err := conn.Read(buf); err != nil {
+		return nil, err
+	}
+	var connectRes struct {
+		Action, TxID int32
+		ConnID       int64
+	}
+	if err := binary.Read(bytes.NewReader(buf), binary.BigEndian, &connectRes); err != nil {
+		return nil, err
+	}
+	if connectRes.Action != udpActionConnect || connectRes.TxID != txID {
+		return nil, fmt.Errorf("tracker %s sent a bad connect response", u.Host)
+	}
+
+	// scrape
+	txID = rand.Int31()
+	req.Reset()
+	binary.Write(req, binary.BigEndian, connectRes.ConnID)
+	binary.Write(req, binary.BigEndian, int32(udpActionScrape))
+	binary.Write(req, binary.BigEndian, txID)
+	req.Write(hash[:])
+	if _, err := conn.Write(req.Bytes()); err != nil {
+		return nil, err
+	}
+
+	buf = make([]byte, 8+12)
+	if _, err := conn.Read(buf); err != nil {
+		return nil, err
+	}
+	var scrapeRes struct {
+		Action, TxID                 int32
+		Seeders, Completed, Leechers int32
+	}
+	if err := binary.Read(bytes.NewReader(buf), binary.BigEndian, &scrapeRes); err != nil {
+		return nil, err
+	}
+	if scrapeRes.Action != udpActionScrape || scrapeRes.TxID != txID {
+		return nil, fmt.Errorf("tracker %s sent a bad scrape response", u.Host)
+	}
+
+	return &ScrapeResult{
+		Seeders:   scrapeRes.Seeders,
+		Leechers:  scrapeRes.Leechers,
+		Completed: scrapeRes.Completed,
+	}, nil
+}
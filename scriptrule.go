@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+
+	db "github.com/akillmer/riptide/database"
+	"github.com/teris-io/shortid"
+)
+
+// Scripted rules run a user-written expression when a torrent hits an
+// event, a step past the fixed pattern rules in rules.go. Every matching
+// rule fires in stored order, so a notify and a label change can live in
+// separate rules.
+
+// Script rule events
+const (
+	ScriptOnAdd      = "on_add"
+	ScriptOnComplete = "on_complete"
+	ScriptOnStall    = "on_stall"
+)
+
+// ScriptRule pairs an expression with the actions to take when it holds
+type ScriptRule struct {
+	ID string `json:"id"`
+	// Name is a label for the rules list, the ID stands in when empty
+	Name string `json:"name,omitempty"`
+	// Event is when the rule runs: on_add, on_complete, or on_stall
+	Event string `json:"event"`
+	// When is the expression gating the actions, see ruleexpr.go for the
+	// language; empty matches every torrent
+	When string `json:"when,omitempty"`
+	// LabelID, MoveTo, Stop, and Notify are the actions. Stop keeps an
+	// added torrent out of the queue, skips seeding on completion, or
+	// turns a stall's requeue into a stop. Notify lands in the activity
+	// feed and the webhook.
+	LabelID string `json:"labelID,omitempty"`
+	MoveTo  string `json:"moveTo,omitempty"`
+	Stop    bool   `json:"stop,omitempty"`
+	Notify  string `json:"notify,omitempty"`
+}
+
+// Validate the rule compiles and actually does something
+func (r *ScriptRule) Validate() error {
+	switch r.Event {
+	case ScriptOnAdd, ScriptOnComplete, ScriptOnStall:
+	default:
+		return errors.New("event must be on_add, on_complete, or on_stall")
+	}
+	if r.When != "" {
+		if _, err := compileRuleExpr(r.When); err != nil {
+			return err
+		}
+	}
+	if r.LabelID == "" && r.MoveTo == "" && !r.Stop && r.Notify == "" {
+		return errors.New("rule does nothing")
+	}
+	return nil
+}
+
+// GetAllScriptRules from the database
+func GetAllScriptRules() ([]*ScriptRule, error) {
+	buf := db.All(db.BucketScripts)
+	if buf == nil {
+		return nil, nil
+	}
+
+	all := make([]*ScriptRule, len(buf))
+	for i, b := range buf {
+		rule := &ScriptRule{}
+		if err := json.Unmarshal(b, rule); err != nil {
+			return nil, err
+		}
+		all[i] = rule
+	}
+
+	return all, nil
+}
+
+// Save this ScriptRule with the database. If it's a new rule then a new
+// short id is assigned.
+func (r *ScriptRule) Save() error {
+	if r.ID == "" {
+		id, err := shortid.Generate()
+		if err != nil {
+			return err
+		}
+		r.ID = id
+	}
+	return db.Put(db.BucketScripts, r.ID, r)
+}
+
+// DeleteScriptRule from the database.
+func DeleteScriptRule(id string) error {
+	return db.Delete(db.BucketScripts, id)
+}
+
+// scriptFields exposes a torrent's fields to the expression language
+func scriptFields(info *TorrentInfo) fieldFn {
+	return func(name string) (interface{}, bool) {
+		switch name {
+		case "name":
+			return info.Name, true
+		case "label":
+			return info.LabelID, true
+		case "status":
+			return string(info.Status), true
+		case "tracker":
+			return strings.Join(trackerHosts(info), " "), true
+		case "size", "totalBytes":
+			return float64(info.TotalBytes), true
+		case "files":
+			return float64(len(info.Files)), true
+		case "private":
+			return info.Private, true
+		case "secondsSeeded":
+			return float64(info.SecondsSeeded), true
+		case "ratio":
+			if info.BytesCompleted == 0 {
+				return float64(0), true
+			}
+			return float64(info.BytesUploaded) / float64(info.BytesCompleted), true
+		}
+		return nil, false
+	}
+}
+
+// runScriptRules evaluates every rule for the event against the torrent,
+// mutating info in place; the caller's save carries the changes. A rule
+// that errors at eval time is skipped, the rest still run.
+func runScriptRules(event string, info *TorrentInfo) {
+	rules, err := GetAllScriptRules()
+	if err != nil {
+		log.Printf("failed to load script rules: %v", err)
+		return
+	}
+
+	for _, rule := range rules {
+		if rule.Event != event {
+			continue
+		}
+
+		if rule.When != "" {
+			expr, err := compileRuleExpr(rule.When)
+			if err != nil {
+				log.Printf("script rule %s no longer compiles: %v", rule.ID, err)
+				continue
+			}
+			ok, err := expr.eval(scriptFields(info))
+			if err != nil {
+				log.Printf("script rule %s failed: %v", rule.ID, err)
+				continue
+			}
+			if !ok {
+				continue
+			}
+		}
+
+		if rule.LabelID != "" {
+			if _, err := GetLabel(rule.LabelID); err == nil {
+				info.LabelID = rule.LabelID
+			}
+		}
+		if rule.MoveTo != "" {
+			info.MoveTo = rule.MoveTo
+		}
+		if rule.Stop {
+			info.Status = StatusStopped
+		}
+		if rule.Notify != "" {
+			appendActivity(info.Hash, info.Name, "rule", rule.Notify)
+			fireWebhook("rule.notify", info.Hash, rule.Notify)
+		}
+	}
+}
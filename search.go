@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Server-side search for the UI's search box. The index holds pre-folded
+// copies of every torrent's name, file paths, and tracker hosts in memory,
+// maintained as torrents are saved and removed, so a query never has to
+// unmarshal the whole collection out of bolt.
+
+// searchDoc is one torrent's searchable text, already lowercased
+type searchDoc struct {
+	name     string
+	files    []string
+	trackers []string
+}
+
+var (
+	searchMu   sync.RWMutex
+	searchDocs = map[string]*searchDoc{}
+)
+
+// SearchResult is the MsgTorrentSearch response, Torrents holds at most the
+// first page of matches and Total says how many there were
+type SearchResult struct {
+	Total    int            `json:"total"`
+	Torrents []*TorrentInfo `json:"torrents"`
+}
+
+// indexTorrent (re)builds the torrent's search document, called on every
+// save so renames and late-arriving metadata stay searchable
+func indexTorrent(info *TorrentInfo) {
+	doc := &searchDoc{name: strings.ToLower(info.Name)}
+	for _, f := range info.Files {
+		doc.files = append(doc.files, strings.ToLower(f))
+	}
+	if m, err := metainfo.ParseMagnetURI(info.Magnet); err == nil {
+		for _, tr := range m.Trackers {
+			if u, err := url.Parse(tr); err == nil && u.Host != "" {
+				doc.trackers = append(doc.trackers, strings.ToLower(u.Host))
+			}
+		}
+	}
+
+	searchMu.Lock()
+	searchDocs[info.Hash] = doc
+	searchMu.Unlock()
+}
+
+// unindexTorrent drops a deleted torrent from the index
+func unindexTorrent(hash string) {
+	searchMu.Lock()
+	delete(searchDocs, hash)
+	searchMu.Unlock()
+}
+
+func (doc *searchDoc) matches(term string) bool {
+	if strings.Contains(doc.name, term) {
+		return true
+	}
+	for _, f := range doc.files {
+		if strings.Contains(f, term) {
+			return true
+		}
+	}
+	for _, tr := range doc.trackers {
+		if strings.Contains(tr, term) {
+			return true
+		}
+	}
+	return false
+}
+
+// searchTorrents returns the hashes whose name, file paths, or tracker hosts
+// contain every term of the query, sorted for stable paging
+func searchTorrents(query string) []string {
+	terms := strings.Fields(strings.ToLower(query))
+	if len(terms) == 0 {
+		return nil
+	}
+
+	searchMu.RLock()
+	defer searchMu.RUnlock()
+
+	var hashes []string
+	for hash, doc := range searchDocs {
+		all := true
+		for _, term := range terms {
+			if !doc.matches(term) {
+				all = false
+				break
+			}
+		}
+		if all {
+			hashes = append(hashes, hash)
+		}
+	}
+	sort.Strings(hashes)
+	return hashes
+}
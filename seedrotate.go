@@ -0,0 +1,138 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"github.com/akillmer/riptide/queue"
+)
+
+// Round-robin seed rotation. With more finished torrents wanting seed time
+// than -max-seeds slots, whoever finished first would hold a slot forever
+// while the long tail never announces. With -seed-rotate set, each interval
+// swaps the idlest seeder out for the torrent that has waited longest, so
+// every seed cycles through upload and announce time.
+
+// seedRotateMins is the -seed-rotate interval, 0 disables rotation
+var seedRotateMins int
+
+// watchSeedRotation runs one rotation per interval
+func watchSeedRotation() {
+	if seedRotateMins <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(seedRotateMins) * time.Minute)
+	for range ticker.C {
+		rotateSeeds()
+	}
+}
+
+// wantsSeedTime reports whether a finished torrent would still be seeding
+// if a slot were free, the inverse of the manager's stop conditions
+func wantsSeedTime(info *TorrentInfo) bool {
+	if info.Status != StatusDone || !info.DataPresent() || !seedingAllowed(info) {
+		return false
+	}
+
+	var ratio float64
+	if info.BytesCompleted > 0 {
+		ratio = float64(info.BytesUploaded) / float64(info.BytesCompleted)
+	}
+	if globalRatio == -1 || ratio >= globalRatio {
+		return false
+	}
+	return !((seedTimeUp(info) || seededEnough(info)) && minRatioMet(info, ratio))
+}
+
+// rotateSeeds gives one waiting torrent a seed slot, displacing the idlest
+// current seeder when every slot is busy
+func rotateSeeds() {
+	allInfo, err := GetAllTorrentInfo()
+	if err != nil {
+		log.Printf("seed rotation failed: %v", err)
+		return
+	}
+
+	var waiting, seeding []*TorrentInfo
+	for _, info := range allInfo {
+		if wantsSeedTime(info) {
+			if _, running := managedTorrents.Load(info.Hash); !running {
+				waiting = append(waiting, info)
+			}
+		}
+		if info.Status == StatusSeeding && !info.ForceStarted {
+			seeding = append(seeding, info)
+		}
+	}
+	if len(waiting) == 0 {
+		return
+	}
+
+	// the torrent idle the longest goes first
+	sort.Slice(waiting, func(i, j int) bool {
+		return waiting[i].LastActive < waiting[j].LastActive
+	})
+	next := waiting[0]
+
+	// spare capacity means nobody has to make room
+	if queue.AcquireSeedSlot(next.Hash) {
+		promoteSeed(next.Hash)
+		return
+	}
+	if len(seeding) == 0 {
+		return
+	}
+
+	// rotate out the seeder doing the least, ties go to whoever has held
+	// a slot the longest
+	sort.Slice(seeding, func(i, j int) bool {
+		a, _ := GetLatestProgress(seeding[i].Hash)
+		b, _ := GetLatestProgress(seeding[j].Hash)
+		if a.BpsUp != b.BpsUp {
+			return a.BpsUp < b.BpsUp
+		}
+		return seeding[i].TimeSeeded < seeding[j].TimeSeeded
+	})
+	out := seeding[0]
+
+	stopTorrent(out.Hash)
+	err = UpdateTorrent(out.Hash, func(info *TorrentInfo) error {
+		// back to the waiting pool, a later rotation brings it around again
+		info.Status = StatusDone
+		return nil
+	})
+	if err != nil {
+		log.Printf("seed rotation failed to park %s: %v", out.Hash, err)
+		return
+	}
+	appendHistory(out.Hash, "seed rotated out", "")
+
+	// the slot frees once the stopped manager finishes closing
+	for i := 0; i < 50; i++ {
+		if queue.AcquireSeedSlot(next.Hash) {
+			promoteSeed(next.Hash)
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	log.Printf("seed rotation: no slot freed up for %s", next.Hash)
+}
+
+// promoteSeed starts a waiting torrent seeding on the slot already claimed
+// under its hash
+func promoteSeed(hash string) {
+	err := UpdateTorrent(hash, func(info *TorrentInfo) error {
+		info.Status = StatusSeeding
+		info.TimeSeeded = time.Now().Unix()
+		return nil
+	})
+	if err != nil {
+		log.Printf("seed rotation failed to promote %s: %v", hash, err)
+		queue.ReleaseSeedSlot(hash)
+		return
+	}
+	appendHistory(hash, "seed rotated in", "")
+	go startTorrent(hash)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+
+	socket "github.com/akillmer/go-socket"
+	db "github.com/akillmer/riptide/database"
+	"github.com/akillmer/riptide/queue"
+)
+
+// Long-term tuning settings persist in the database so process-manager
+// restarts don't have to repeat the same CLI flags forever. Precedence at
+// boot: a flag passed on the command line wins and its value is stored for
+// later runs, then the stored value, then the flag's default.
+
+const serverSettingsKey = "serverSettings"
+
+// ServerSettings is the stored shape, pointers so an absent field stays
+// distinguishable from a zero value
+type ServerSettings struct {
+	MaxActiveTorrents *int     `json:"maxActiveTorrents,omitempty"`
+	MaxSeedTorrents   *int     `json:"maxSeedTorrents,omitempty"`
+	GlobalRatio       *float64 `json:"globalRatio,omitempty"`
+	SeedHours         *int     `json:"seedHours,omitempty"`
+	MaxDownloadSpeed  *int     `json:"maxDownloadSpeed,omitempty"`
+	MaxUploadSpeed    *int     `json:"maxUploadSpeed,omitempty"`
+}
+
+// settingValues and settingSources back the settings API response, recording
+// what each knob resolved to and which layer of the precedence chose it
+var (
+	settingValues  = map[string]interface{}{}
+	settingSources = map[string]string{}
+)
+
+// SettingsReport is the MsgSettings response, Precedence spells the rules
+// out so clients don't have to guess why a value took effect
+type SettingsReport struct {
+	Precedence string                 `json:"precedence"`
+	Values     map[string]interface{} `json:"values"`
+	Sources    map[string]string      `json:"sources"`
+}
+
+func getServerSettings() *ServerSettings {
+	s := &ServerSettings{}
+	if buf, err := db.Get(db.BucketSettings, serverSettingsKey); err == nil && buf != nil {
+		json.Unmarshal(buf, s)
+	}
+	return s
+}
+
+// loadServerSettings reconciles stored settings with this run's flags, called
+// once the database is open and before any of the values are used
+func loadServerSettings(maxActive, maxSeeds, dl, ul *int) {
+	passed := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { passed[f.Name] = true })
+
+	s := getServerSettings()
+
+	reconcileInt := func(name, flagName string, live *int, stored **int) {
+		switch {
+		case passed[flagName]:
+			v := *live
+			*stored = &v
+			settingSources[name] = "flag"
+		case *stored != nil:
+			*live = **stored
+			settingSources[name] = "stored"
+		default:
+			settingSources[name] = "default"
+		}
+		settingValues[name] = *live
+	}
+
+	reconcileInt("maxActiveTorrents", "max", maxActive, &s.MaxActiveTorrents)
+	reconcileInt("maxSeedTorrents", "max-seeds", maxSeeds, &s.MaxSeedTorrents)
+	reconcileInt("seedHours", "seed-hours", &seedHours, &s.SeedHours)
+	reconcileInt("maxDownloadSpeed", "dl", dl, &s.MaxDownloadSpeed)
+	reconcileInt("maxUploadSpeed", "ul", ul, &s.MaxUploadSpeed)
+
+	switch {
+	case passed["ratio"]:
+		v := globalRatio
+		s.GlobalRatio = &v
+		settingSources["globalRatio"] = "flag"
+	case s.GlobalRatio != nil:
+		globalRatio = *s.GlobalRatio
+		settingSources["globalRatio"] = "stored"
+	default:
+		settingSources["globalRatio"] = "default"
+	}
+	settingValues["globalRatio"] = globalRatio
+
+	if err := db.Put(db.BucketSettings, serverSettingsKey, s); err != nil {
+		log.Printf("failed to persist server settings: %v", err)
+	}
+}
+
+func settingsReport() *SettingsReport {
+	return &SettingsReport{
+		Precedence: "command-line flag, then stored setting, then flag default; flags are stored for later runs",
+		Values:     settingValues,
+		Sources:    settingSources,
+	}
+}
+
+// setServerSettings merges the non-nil fields into the stored settings and
+// applies the ones that can change on a running server. Speed caps and the
+// active-torrent limit are wired up at boot, they take effect next restart.
+func setServerSettings(payload interface{}) error {
+	data := &ServerSettings{}
+	if err := decodePayload(payload, data); err != nil {
+		return err
+	}
+
+	s := getServerSettings()
+	if data.MaxActiveTorrents != nil {
+		s.MaxActiveTorrents = data.MaxActiveTorrents
+		settingValues["maxActiveTorrents"] = *data.MaxActiveTorrents
+		settingSources["maxActiveTorrents"] = "stored"
+	}
+	if data.MaxSeedTorrents != nil {
+		s.MaxSeedTorrents = data.MaxSeedTorrents
+		queue.SetMaxSeeds(*data.MaxSeedTorrents)
+		settingValues["maxSeedTorrents"] = *data.MaxSeedTorrents
+		settingSources["maxSeedTorrents"] = "stored"
+	}
+	if data.GlobalRatio != nil {
+		s.GlobalRatio = data.GlobalRatio
+		globalRatio = *data.GlobalRatio
+		settingValues["globalRatio"] = globalRatio
+		settingSources["globalRatio"] = "stored"
+	}
+	if data.SeedHours != nil {
+		s.SeedHours = data.SeedHours
+		seedHours = *data.SeedHours
+		settingValues["seedHours"] = seedHours
+		settingSources["seedHours"] = "stored"
+	}
+	if data.MaxDownloadSpeed != nil {
+		s.MaxDownloadSpeed = data.MaxDownloadSpeed
+		settingValues["maxDownloadSpeed"] = *data.MaxDownloadSpeed
+		settingSources["maxDownloadSpeed"] = "stored"
+	}
+	if data.MaxUploadSpeed != nil {
+		s.MaxUploadSpeed = data.MaxUploadSpeed
+		settingValues["maxUploadSpeed"] = *data.MaxUploadSpeed
+		settingSources["maxUploadSpeed"] = "stored"
+	}
+
+	if err := db.Put(db.BucketSettings, serverSettingsKey, s); err != nil {
+		return err
+	}
+	return socket.Broadcast(MsgSettings, settingsReport())
+}
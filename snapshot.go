@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	db "github.com/akillmer/riptide/database"
+	"github.com/akillmer/riptide/queue"
+)
+
+// Shutdown snapshots: a graceful exit writes the torrent, label, and queue
+// state to a timestamped JSON file so a later bolt corruption can be
+// recovered by hand (or by re-adding the magnets) without a byte-level
+// backup of the database. Old snapshots rotate away.
+
+// snapshotDir is where shutdown snapshots land, empty disables them
+var snapshotDir string
+
+// maxSnapshots kept before the oldest rotate off
+const maxSnapshots = 10
+
+const snapshotPrefix = "riptide-"
+
+// dbSnapshot is the export format, records stay as stored so the snapshot
+// never lags behind schema changes
+type dbSnapshot struct {
+	TakenAt  string            `json:"takenAt"`
+	Torrents []json.RawMessage `json:"torrents"`
+	Labels   []json.RawMessage `json:"labels"`
+	Queued   []string          `json:"queued"`
+}
+
+// writeShutdownSnapshot exports the buckets worth recovering, called with
+// the database still open
+func writeShutdownSnapshot() {
+	if snapshotDir == "" {
+		return
+	}
+	if err := os.MkdirAll(snapshotDir, 0700); err != nil {
+		log.Printf("failed to create snapshot dir: %v", err)
+		return
+	}
+
+	snap := &dbSnapshot{TakenAt: time.Now().Format(time.RFC3339)}
+	for _, buf := range db.All(db.BucketTorrents) {
+		snap.Torrents = append(snap.Torrents, json.RawMessage(buf))
+	}
+	for _, buf := range db.All(db.BucketLabels) {
+		snap.Labels = append(snap.Labels, json.RawMessage(buf))
+	}
+	if hashes, err := queue.Hashes(); err == nil {
+		snap.Queued = hashes
+	}
+
+	buf, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		log.Printf("failed to marshal snapshot: %v", err)
+		return
+	}
+
+	name := snapshotPrefix + time.Now().Format("20060102-150405") + ".json"
+	target := filepath.Join(snapshotDir, name)
+
+	// write-then-rename so a crash mid-write can't leave a torn snapshot
+	// with a valid name
+	tmp := target + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0600); err != nil {
+		log.Printf("failed to write snapshot: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		log.Printf("failed to finalize snapshot: %v", err)
+		return
+	}
+
+	rotateSnapshots()
+	log.Printf("wrote shutdown snapshot %s", target)
+}
+
+// rotateSnapshots trims the directory down to maxSnapshots, names embed the
+// timestamp so lexical order is age order
+func rotateSnapshots() {
+	entries, err := os.ReadDir(snapshotDir)
+	if err != nil {
+		return
+	}
+
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, snapshotPrefix) && strings.HasSuffix(name, ".json") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for len(names) > maxSnapshots {
+		os.Remove(filepath.Join(snapshotDir, names[0]))
+		names = names[1:]
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Clients in different locales kept rendering the bare Unix timestamps
+// inconsistently, so every outbound payload now carries RFC 3339 renditions
+// alongside them and MsgClientInit reports the server's own clock and zone.
+
+// isoTime formats a Unix timestamp as RFC 3339 in the server's zone, empty
+// for the zero value so omitempty drops it
+func isoTime(unix int64) string {
+	if unix == 0 {
+		return ""
+	}
+	return time.Unix(unix, 0).Format(time.RFC3339)
+}
+
+// MarshalJSON adds RFC 3339 renditions of the torrent's timestamps so
+// clients don't each reinvent locale-safe formatting
+func (t *TorrentInfo) MarshalJSON() ([]byte, error) {
+	type alias TorrentInfo
+	return json.Marshal(&struct {
+		*alias
+		AddedAt      string `json:"addedAt,omitempty"`
+		StartedAt    string `json:"startedAt,omitempty"`
+		FinishedAt   string `json:"finishedAt,omitempty"`
+		SeededAt     string `json:"seededAt,omitempty"`
+		LastActiveAt string `json:"lastActiveAt,omitempty"`
+	}{
+		alias:        (*alias)(t),
+		AddedAt:      isoTime(t.TimeAdded),
+		StartedAt:    isoTime(t.TimeStarted),
+		FinishedAt:   isoTime(t.TimeFinished),
+		SeededAt:     isoTime(t.TimeSeeded),
+		LastActiveAt: isoTime(t.LastActive),
+	})
+}
+
+// MarshalJSON mirrors the event's Unix time as RFC 3339
+func (e *TorrentEvent) MarshalJSON() ([]byte, error) {
+	type alias TorrentEvent
+	return json.Marshal(&struct {
+		*alias
+		TimeISO string `json:"timeISO,omitempty"`
+	}{alias: (*alias)(e), TimeISO: isoTime(e.Time)})
+}
+
+// MarshalJSON mirrors the event's Unix time as RFC 3339
+func (e *ActivityEvent) MarshalJSON() ([]byte, error) {
+	type alias ActivityEvent
+	return json.Marshal(&struct {
+		*alias
+		TimeISO string `json:"timeISO,omitempty"`
+	}{alias: (*alias)(e), TimeISO: isoTime(e.Time)})
+}
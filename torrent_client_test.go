@@ -0,0 +1,34 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// addMagnet is the chokepoint every magnet passes through, exercising it
+// against the mock proves the tracker policy without a real client
+func TestAddMagnetAppliesTrackerPolicy(t *testing.T) {
+	mock := &mockTorrentClient{}
+	client = mock
+	noUDPTrackers = true
+	defer func() {
+		client = nil
+		noUDPTrackers = false
+	}()
+
+	uri := "magnet:?xt=urn:btih:deadbeefdeadbeefdeadbeefdeadbeefdeadbeef" +
+		"&dn=test&tr=udp%3A%2F%2Ftracker.example%3A1337&tr=http%3A%2F%2Ftracker.example%2Fannounce"
+	if _, err := addMagnet(uri); err != nil {
+		t.Fatalf("addMagnet: %v", err)
+	}
+
+	if len(mock.Magnets) != 1 {
+		t.Fatalf("expected 1 recorded magnet, got %d", len(mock.Magnets))
+	}
+	if strings.Contains(mock.Magnets[0], "udp") {
+		t.Errorf("udp tracker survived the policy: %s", mock.Magnets[0])
+	}
+	if !strings.Contains(mock.Magnets[0], "http") {
+		t.Errorf("http tracker was stripped: %s", mock.Magnets[0])
+	}
+}
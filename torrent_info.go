@@ -2,11 +2,22 @@ package main
 
 import (
 	"encoding/json"
+	"path"
+	"sync/atomic"
 
 	socket "github.com/akillmer/go-socket"
 	db "github.com/akillmer/riptide/database"
+	"github.com/akillmer/riptide/fs"
 )
 
+// lastSeq is the most recent sequence number handed to a saved TorrentInfo,
+// restored from the database at startup so it stays monotonic across restarts
+var lastSeq int64
+
+func nextSeq() int64 {
+	return atomic.AddInt64(&lastSeq, 1)
+}
+
 // Status describes the current state of a stored torrent
 type Status string
 
@@ -18,6 +29,7 @@ const (
 	StatusPending        = "PENDING"
 	StatusDone           = "DONE"
 	StatusSeeding        = "SEEDING"
+	StatusError          = "ERROR"
 )
 
 // TorrentInfo is static meta information for a particular torrent
@@ -29,6 +41,80 @@ type TorrentInfo struct {
 	Status     Status `json:"status"`
 	Magnet     string `json:"magnet"`
 	LabelID    string `json:"labelID"`
+	// DiskName is the top folder the torrent client writes to, it only
+	// differs from Name after the user renames the torrent
+	DiskName string `json:"diskName,omitempty"`
+	// Dir is the directory the payload currently lives in, empty means
+	// the download directory
+	Dir string `json:"dir,omitempty"`
+	// FileNames maps a file's original path within the torrent to the
+	// name the user gave it, so restarts don't break renamed paths
+	FileNames map[string]string `json:"fileNames,omitempty"`
+	// Seq increases with every save, reconnecting clients use it to ask
+	// for just the torrents that changed while they were away
+	Seq int64 `json:"seq"`
+	// RetryCount is how many automatic retries a transiently-failed torrent
+	// has burned, NextRetry is when the next one fires (0: none scheduled)
+	RetryCount int   `json:"retryCount,omitempty"`
+	NextRetry  int64 `json:"nextRetry,omitempty"`
+	// MetaState is the resolution sub-status of a PENDING torrent: QUEUED
+	// while waiting for a metadata fetch slot, RESOLVING while it holds one
+	MetaState string `json:"metaState,omitempty"`
+	// Error explains why the torrent has StatusError
+	Error string `json:"error,omitempty"`
+	// Priority is the torrent's bandwidth class, empty means PriorityNormal
+	Priority string `json:"priority,omitempty"`
+	// PieceOrder is the piece selection strategy: rarest (the default),
+	// sequential, or random
+	PieceOrder string `json:"pieceOrder,omitempty"`
+	// Files lists the torrent's file paths once its metadata is known,
+	// sorted so file sets can be compared between torrents
+	Files []string `json:"files,omitempty"`
+	// SkipFiles lists file paths the user deselected, those files aren't
+	// downloaded and TotalBytes counts only the wanted ones
+	SkipFiles []string `json:"skipFiles,omitempty"`
+	// TimeSeeded is when the torrent entered StatusSeeding, for label rules
+	// like "stop after N days"
+	TimeSeeded int64 `json:"timeSeeded,omitempty"`
+	// SecondsSeeded is the cumulative time spent seeding across all
+	// sessions, the -seed-hours stop condition counts against it
+	SecondsSeeded int64 `json:"secondsSeeded,omitempty"`
+	// ForceStarted torrents run outside the queue and ignore the -max and
+	// -max-seeds limits until the flag is cleared or they're stopped
+	ForceStarted bool `json:"forceStarted,omitempty"`
+	// UnpackStatus tracks post-completion archive extraction when the
+	// torrent's label opts in, empty until it starts
+	UnpackStatus string `json:"unpackStatus,omitempty"`
+	// TimeStarted is when the torrent first became active, TimeFinished
+	// when its download completed, and LastActive the most recent tick it
+	// was downloading or seeding
+	TimeStarted  int64 `json:"timeStarted,omitempty"`
+	TimeFinished int64 `json:"timeFinished,omitempty"`
+	LastActive   int64 `json:"lastActive,omitempty"`
+	// MoveTo overrides where the completed data moves, set by rules, it
+	// takes precedence over the label's MoveTo
+	MoveTo string `json:"moveTo,omitempty"`
+	// Private mirrors the metainfo's BEP 27 flag, such torrents never use
+	// DHT or PEX peer discovery
+	Private bool `json:"private,omitempty"`
+	// SwarmSeeders, SwarmLeechers, and SwarmCompleted are the latest tracker
+	// scrape counts, LastScraped is when they were taken
+	SwarmSeeders   int32 `json:"swarmSeeders,omitempty"`
+	SwarmLeechers  int32 `json:"swarmLeechers,omitempty"`
+	SwarmCompleted int32 `json:"swarmCompleted,omitempty"`
+	LastScraped    int64 `json:"lastScraped,omitempty"`
+	// LastVerified is when the torrent's data last passed re-verification
+	LastVerified int64 `json:"lastVerified,omitempty"`
+	// MoveWarning notes a completion-move collision, either the alternate
+	// destination that was used or that the move was skipped
+	MoveWarning string `json:"moveWarning,omitempty"`
+	// PendingMove is the directory the torrent's completed data should move
+	// to but couldn't yet, retries are underway while it's set
+	PendingMove string `json:"pendingMove,omitempty"`
+	// BytesCompleted and BytesUploaded are checkpoints of the torrent's
+	// progress counters so ratio isn't lost across stops and restarts
+	BytesCompleted int64 `json:"bytesCompleted"`
+	BytesUploaded  int64 `json:"bytesUploaded"`
 }
 
 // GetTorrentInfo from the database by hash
@@ -63,13 +149,34 @@ func GetAllTorrentInfo() ([]*TorrentInfo, error) {
 
 // SaveAndBroadcast the Torrent info
 func (t *TorrentInfo) SaveAndBroadcast() error {
+	t.Seq = nextSeq()
 	if err := db.Put(db.BucketTorrents, t.Hash, t); err != nil {
 		return err
 	}
+	indexTorrent(t)
 	socket.Broadcast(MsgTorrentInfo, t)
 	return nil
 }
 
+// DataPath is where the torrent's data lives on disk, accounting for the
+// incomplete directory and any rename the user has made
+func (t *TorrentInfo) DataPath() string {
+	dir := t.Dir
+	if dir == "" {
+		dir = downloadDir
+	}
+	if t.DiskName != "" {
+		return path.Join(dir, t.DiskName)
+	}
+	return path.Join(dir, t.Name)
+}
+
+// DataPresent reports whether the torrent's payload still exists on disk
+func (t *TorrentInfo) DataPresent() bool {
+	_, err := fs.Default.Stat(t.DataPath())
+	return err == nil
+}
+
 // GetLabel assigned to this torrent info from the database
 func (t *TorrentInfo) GetLabel() (*Label, error) {
 	label := &Label{}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// defaultPageSize bounds how many torrents go out in one list page, large
+// collections would otherwise push megabytes of JSON per request
+const defaultPageSize = 100
+
+// TorrentListPayload for MsgTorrentList, all filters are optional and combine
+// with AND. Page counts from zero.
+type TorrentListPayload struct {
+	Status   Status `json:"status,omitempty"`
+	LabelID  string `json:"labelID,omitempty"`
+	Name     string `json:"name,omitempty"`
+	SortBy   string `json:"sortBy,omitempty"`
+	SortDesc bool   `json:"sortDesc,omitempty"`
+	Page     int    `json:"page,omitempty"`
+	PageSize int    `json:"pageSize,omitempty"`
+}
+
+// TorrentListPage is one page of a filtered torrent listing, Total is the
+// number of torrents matching the filters across all pages
+type TorrentListPage struct {
+	Total    int            `json:"total"`
+	Page     int            `json:"page"`
+	PageSize int            `json:"pageSize"`
+	Torrents []*TorrentInfo `json:"torrents"`
+}
+
+// validSortKey reports whether the name is a sort listTorrents understands
+func validSortKey(key string) bool {
+	switch key {
+	case "timeAdded", "name", "size", "status":
+		return true
+	}
+	return false
+}
+
+// filterTorrents returns every stored torrent matching the payload's
+// filters, ignoring its paging and sorting fields
+func filterTorrents(data *TorrentListPayload) ([]*TorrentInfo, error) {
+	allInfo, err := GetAllTorrentInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.ToLower(data.Name)
+	matched := []*TorrentInfo{}
+	for _, info := range allInfo {
+		if data.Status != "" && info.Status != data.Status {
+			continue
+		}
+		if data.LabelID != "" && info.LabelID != data.LabelID {
+			continue
+		}
+		if name != "" && !strings.Contains(strings.ToLower(info.Name), name) {
+			continue
+		}
+		matched = append(matched, info)
+	}
+
+	return matched, nil
+}
+
+// listTorrents filters, sorts, and pages the stored torrents
+func listTorrents(data *TorrentListPayload) (*TorrentListPage, error) {
+	matched, err := filterTorrents(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// requests that don't name a sort get the server's configured order, so
+	// the init payload and unadorned list calls already arrive presorted
+	sortBy, sortDesc := data.SortBy, data.SortDesc
+	if sortBy == "" {
+		cfg := getUIConfig()
+		sortBy, sortDesc = cfg.SortBy, cfg.SortDesc
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+		if sortDesc {
+			a, b = b, a
+		}
+		switch sortBy {
+		case "name":
+			if x, y := strings.ToLower(a.Name), strings.ToLower(b.Name); x != y {
+				return x < y
+			}
+		case "size":
+			if a.TotalBytes != b.TotalBytes {
+				return a.TotalBytes < b.TotalBytes
+			}
+		case "status":
+			if a.Status != b.Status {
+				return a.Status < b.Status
+			}
+		default: // timeAdded
+			if a.TimeAdded != b.TimeAdded {
+				return a.TimeAdded < b.TimeAdded
+			}
+		}
+		// ties break on hash so page boundaries hold still between requests
+		return a.Hash < b.Hash
+	})
+
+	pageSize := data.PageSize
+	if pageSize <= 0 {
+		// clients that don't ask for a size get the configured default
+		pageSize = getUIConfig().PageSize
+	}
+	if pageSize <= 0 || pageSize > 1000 {
+		pageSize = defaultPageSize
+	}
+	page := data.Page
+	if page < 0 {
+		page = 0
+	}
+
+	start := page * pageSize
+	if start > len(matched) {
+		start = len(matched)
+	}
+	end := start + pageSize
+	if end > len(matched) {
+		end = len(matched)
+	}
+
+	return &TorrentListPage{
+		Total:    len(matched),
+		Page:     page,
+		PageSize: pageSize,
+		Torrents: matched[start:end],
+	}, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"sync"
+)
+
+// Every stored torrent gets a manager goroutine that owns its TorrentInfo.
+// Status transitions used to be racy read-modify-write cycles against the
+// database from both the API handlers and the startTorrent loop; now they are
+// funneled through the manager's channel one command at a time.
+
+type torrentCmd struct {
+	apply func(info *TorrentInfo) error
+	reply chan error
+}
+
+type torrentManager struct {
+	hash string
+	cmds chan torrentCmd
+}
+
+var torrentManagers = sync.Map{}
+
+// managerFor returns the torrent's manager, starting its goroutine if needed.
+// Managers are cheap and stick around until restart, even after their torrent
+// is deleted, so nobody is ever left holding a dead channel.
+func managerFor(hash string) *torrentManager {
+	if v, ok := torrentManagers.Load(hash); ok {
+		return v.(*torrentManager)
+	}
+
+	m := &torrentManager{hash: hash, cmds: make(chan torrentCmd)}
+	if actual, loaded := torrentManagers.LoadOrStore(hash, m); loaded {
+		return actual.(*torrentManager)
+	}
+
+	go m.run()
+	return m
+}
+
+func (m *torrentManager) run() {
+	for cmd := range m.cmds {
+		info, err := GetTorrentInfo(m.hash)
+		if err != nil {
+			cmd.reply <- err
+			continue
+		}
+		if err := cmd.apply(info); err != nil {
+			cmd.reply <- err
+			continue
+		}
+		cmd.reply <- info.SaveAndBroadcast()
+	}
+}
+
+// UpdateTorrent applies fn to the torrent's info inside its manager goroutine.
+// The load, the mutation, and the save-and-broadcast happen as one serialized
+// step, so concurrent transitions can't clobber each other.
+func UpdateTorrent(hash string, fn func(info *TorrentInfo) error) error {
+	m := managerFor(hash)
+	cmd := torrentCmd{apply: fn, reply: make(chan error)}
+	m.cmds <- cmd
+	return <-cmd.reply
+}
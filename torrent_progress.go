@@ -1,10 +1,30 @@
 package main
 
 import (
+	"sync"
+	"time"
+
 	socket "github.com/akillmer/go-socket"
 	"github.com/anacrolix/torrent"
 )
 
+// stallAfter is how long a downloading torrent can go without receiving any
+// payload bytes before it's flagged as stalled, set from the -stall flag,
+// zero disables stall detection entirely
+var stallAfter = 10 * time.Minute
+
+// latestProgress keeps the most recent TorrentProgress per hash so other
+// parts of the server (like the dashboard) can see current rates
+var latestProgress = sync.Map{}
+
+// GetLatestProgress for a torrent by hash, if any has been broadcast
+func GetLatestProgress(hash string) (TorrentProgress, bool) {
+	if v, ok := latestProgress.Load(hash); ok {
+		return v.(TorrentProgress), true
+	}
+	return TorrentProgress{}, false
+}
+
 // TorrentProgress stores the latest status of an active torrent
 type TorrentProgress struct {
 	Hash           string  `json:"hash"`
@@ -15,6 +35,36 @@ type TorrentProgress struct {
 	ActivePeers    int     `json:"activePeers"`
 	TotalPeers     int     `json:"totalPeers"`
 	Ratio          float64 `json:"ratio"`
+	// Seeders and Leechers split the connected peers by whether they already
+	// have the whole torrent
+	Seeders  int `json:"seeders"`
+	Leechers int `json:"leechers"`
+	// DistributedCopies approximates swarm availability as connected seeds
+	// plus our own completed fraction, the client doesn't expose per-peer
+	// piece counts
+	DistributedCopies float64 `json:"distributedCopies"`
+	// Stalled when a downloading torrent has received no payload bytes for
+	// stallAfter, auto-management rules key off this
+	Stalled bool `json:"stalled"`
+	// uploadedOffset carries bytes uploaded in previous runs, the torrent
+	// client's own counters always start from zero
+	uploadedOffset int64
+	// lastPayload is the last time BytesCompleted grew
+	lastPayload time.Time
+	// lastUpdate times the gap between updates, the manager's tick is
+	// configurable so rates can't assume one second between passes
+	lastUpdate time.Time
+}
+
+// SeedFrom primes the progress with the checkpoint saved in the torrent's
+// info so upload counters and ratio continue where they left off
+func (tp *TorrentProgress) SeedFrom(info *TorrentInfo) {
+	tp.BytesCompleted = info.BytesCompleted
+	tp.BytesUploaded = info.BytesUploaded
+	tp.uploadedOffset = info.BytesUploaded
+	if tp.BytesCompleted > 0 {
+		tp.Ratio = float64(tp.BytesUploaded) / float64(tp.BytesCompleted)
+	}
 }
 
 // Reset the progress to show no activity
@@ -23,30 +73,61 @@ func (tp *TorrentProgress) Reset() {
 	tp.BpsDown = 0
 	tp.ActivePeers = 0
 	tp.TotalPeers = 0
+	tp.Seeders = 0
+	tp.Leechers = 0
+	tp.DistributedCopies = 0
+	tp.Stalled = false
 }
 
 // Update a torrent's progress
 func (tp *TorrentProgress) Update(t *torrent.Torrent) {
 	conn := t.Stats().ConnStats
+	prevCompleted := tp.BytesCompleted
+	prevUploaded := tp.BytesUploaded
+
+	seconds := time.Since(tp.lastUpdate).Seconds()
+	if tp.lastUpdate.IsZero() || seconds <= 0 {
+		seconds = 1
+	}
+	tp.lastUpdate = time.Now()
 
 	// average out this and last BpsUp to be a bit smoother
-	tp.BpsUp = (tp.BpsUp + (conn.BytesWritten - tp.BytesUploaded)) / 2
-	tp.BytesUploaded = conn.BytesWritten
+	written := tp.uploadedOffset + conn.BytesWritten
+	tp.BpsUp = (tp.BpsUp + int64(float64(written-tp.BytesUploaded)/seconds)) / 2
+	tp.BytesUploaded = written
 
-	tp.BpsDown = (tp.BpsDown + (t.BytesCompleted() - tp.BytesCompleted)) / 2
+	tp.BpsDown = (tp.BpsDown + int64(float64(t.BytesCompleted()-tp.BytesCompleted)/seconds)) / 2
 	tp.BytesCompleted = t.BytesCompleted()
 
-	tp.ActivePeers = t.Stats().ActivePeers
-	tp.TotalPeers = t.Stats().TotalPeers
+	stats := t.Stats()
+	tp.ActivePeers = stats.ActivePeers
+	tp.TotalPeers = stats.TotalPeers
+	tp.Seeders = stats.ConnectedSeeders
+	tp.Leechers = stats.ActivePeers - stats.ConnectedSeeders
+
+	tp.DistributedCopies = float64(stats.ConnectedSeeders)
+	if length := t.Length(); length > 0 {
+		tp.DistributedCopies += float64(tp.BytesCompleted) / float64(length)
+	}
+
+	now := time.Now()
+	if tp.lastPayload.IsZero() || tp.BytesCompleted > prevCompleted {
+		tp.lastPayload = now
+	}
+	tp.Stalled = stallAfter > 0 && tp.BytesCompleted < t.Length() &&
+		now.Sub(tp.lastPayload) >= stallAfter
 
 	if tp.BytesCompleted == 0 {
 		tp.Ratio = 0
 	} else {
 		tp.Ratio = float64(tp.BytesUploaded) / float64(tp.BytesCompleted)
 	}
+
+	addBudgetUsage(tp.BytesCompleted-prevCompleted, tp.BytesUploaded-prevUploaded)
 }
 
 // Broadcast the torrent's progress
 func (tp *TorrentProgress) Broadcast() error {
+	latestProgress.Store(tp.Hash, *tp)
 	return socket.Broadcast(MsgTorrentProgress, tp)
 }
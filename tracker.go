@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Tracker policy knobs, set from flags. Some private trackers require a
+// specific client identity or forbid announcing outside a proxy, and UDP
+// announces can't go through an HTTP proxy at all.
+var (
+	// noUDPTrackers strips udp:// trackers from every added magnet
+	noUDPTrackers bool
+	// proxyOnly refuses announce transports that can't use the configured
+	// proxy, which today means the same as noUDPTrackers
+	proxyOnly bool
+)
+
+// filterMagnetTrackers strips udp:// trackers from a magnet URI when the
+// tracker policy disallows them
+func filterMagnetTrackers(uri string) string {
+	if !noUDPTrackers {
+		return uri
+	}
+
+	magnet, err := metainfo.ParseMagnetURI(uri)
+	if err != nil {
+		return uri
+	}
+
+	kept := magnet.Trackers[:0]
+	for _, tr := range magnet.Trackers {
+		if !strings.HasPrefix(strings.ToLower(tr), "udp:") {
+			kept = append(kept, tr)
+		}
+	}
+	magnet.Trackers = kept
+
+	return magnet.String()
+}
+
+// addMagnet hands a magnet to the torrent client with the tracker policy
+// applied, all magnets should enter the client through here
+func addMagnet(uri string) (*torrent.Torrent, error) {
+	return client.AddMagnet(filterMagnetTrackers(uri))
+}
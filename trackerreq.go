@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+
+	socket "github.com/akillmer/go-socket"
+	db "github.com/akillmer/riptide/database"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Per-tracker minimums. Strict private trackers punish leaving a torrent
+// before a ratio or seed-time floor, so the user records those floors per
+// tracker host and every stop decision consults them: a torrent whose
+// trackers still hold it in debt won't leave seeding no matter what the
+// global ratio or a label's rules say, and the RATIO_DEFICIT queue policy
+// counts the tracker's floor as part of the debt.
+
+// TrackerRequirement is the floor one tracker host imposes; both fields at
+// zero removes the entry
+type TrackerRequirement struct {
+	Host         string  `json:"host"`
+	MinRatio     float64 `json:"minRatio,omitempty"`
+	MinSeedHours int     `json:"minSeedHours,omitempty"`
+}
+
+// trackerReqKey is the settings record the requirement map lives under
+const trackerReqKey = "trackerRequirements"
+
+// getTrackerRequirements returns the stored map keyed by tracker host
+func getTrackerRequirements() map[string]*TrackerRequirement {
+	reqs := map[string]*TrackerRequirement{}
+	if buf, err := db.Get(db.BucketSettings, trackerReqKey); err == nil {
+		json.Unmarshal(buf, &reqs)
+	}
+	return reqs
+}
+
+// setTrackerRequirement upserts one host's floor and broadcasts the new map,
+// zeroed minimums delete the host
+func setTrackerRequirement(req *TrackerRequirement) error {
+	host := strings.ToLower(strings.TrimSpace(req.Host))
+	if host == "" || req.MinRatio < 0 || req.MinSeedHours < 0 {
+		return ErrBadRequest
+	}
+
+	reqs := getTrackerRequirements()
+	if req.MinRatio == 0 && req.MinSeedHours == 0 {
+		delete(reqs, host)
+	} else {
+		req.Host = host
+		reqs[host] = req
+	}
+
+	if err := db.Put(db.BucketSettings, trackerReqKey, reqs); err != nil {
+		return err
+	}
+	return socket.Broadcast(MsgTrackerReqs, reqs)
+}
+
+// trackerHosts is the torrent's tracker hostnames, lowercased without ports
+func trackerHosts(info *TorrentInfo) []string {
+	magnet, err := metainfo.ParseMagnetURI(info.Magnet)
+	if err != nil {
+		return nil
+	}
+
+	var hosts []string
+	for _, tr := range magnet.Trackers {
+		if u, err := url.Parse(tr); err == nil && u.Hostname() != "" {
+			hosts = append(hosts, strings.ToLower(u.Hostname()))
+		}
+	}
+	return hosts
+}
+
+// requirementFor folds the torrent's trackers into one strictest floor,
+// nil when none of its hosts have an entry
+func requirementFor(info *TorrentInfo) *TrackerRequirement {
+	reqs := getTrackerRequirements()
+	if len(reqs) == 0 {
+		return nil
+	}
+
+	var strictest *TrackerRequirement
+	for _, host := range trackerHosts(info) {
+		req, ok := reqs[host]
+		if !ok {
+			continue
+		}
+		if strictest == nil {
+			strictest = &TrackerRequirement{Host: req.Host}
+		}
+		if req.MinRatio > strictest.MinRatio {
+			strictest.MinRatio = req.MinRatio
+		}
+		if req.MinSeedHours > strictest.MinSeedHours {
+			strictest.MinSeedHours = req.MinSeedHours
+		}
+	}
+	return strictest
+}
+
+// trackerRequirementMet reports whether every tracker floor is satisfied,
+// stop decisions must not fire while this is false
+func trackerRequirementMet(info *TorrentInfo, ratio float64) bool {
+	req := requirementFor(info)
+	if req == nil {
+		return true
+	}
+	if req.MinRatio > 0 && ratio < req.MinRatio {
+		return false
+	}
+	if req.MinSeedHours > 0 && info.SecondsSeeded < int64(req.MinSeedHours)*3600 {
+		return false
+	}
+	return true
+}
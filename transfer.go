@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	db "github.com/akillmer/riptide/database"
+)
+
+// Per-torrent transfer rollups: every running torrent accumulates its bytes
+// moved into one sample per hour, kept for a week. Clients fetch them via
+// MsgTorrentTransfer to graph throughput and see which torrents actually
+// earn ratio.
+
+// TransferSample is one hour of a torrent's transfer activity
+type TransferSample struct {
+	// Time is the start of the sample's hour
+	Time int64 `json:"time"`
+	Up   int64 `json:"up"`
+	Down int64 `json:"down"`
+}
+
+// maxTransferSamples keeps a week of hourly samples per torrent
+const maxTransferSamples = 24 * 7
+
+var (
+	transferMu sync.Mutex
+	// transferLast holds each torrent's last seen absolute counters so the
+	// next tick's delta can be taken
+	transferLast = map[string][2]int64{}
+	// transferNow holds each torrent's in-progress hour, flushed to the
+	// database when the hour rolls over or the torrent stops
+	transferNow = map[string]*TransferSample{}
+)
+
+// recordTransfer folds the torrent's current absolute counters into its
+// hourly sample, called once per progress tick
+func recordTransfer(hash string, upTotal, downTotal int64) {
+	transferMu.Lock()
+	defer transferMu.Unlock()
+
+	hour := time.Now().Truncate(time.Hour).Unix()
+
+	if last, ok := transferLast[hash]; ok {
+		up, down := upTotal-last[0], downTotal-last[1]
+		// counters reset when a torrent restarts, skip the negative jump
+		if up < 0 {
+			up = 0
+		}
+		if down < 0 {
+			down = 0
+		}
+
+		cur := transferNow[hash]
+		if cur == nil || cur.Time != hour {
+			if cur != nil {
+				flushTransferSample(hash, cur)
+			}
+			cur = &TransferSample{Time: hour}
+			transferNow[hash] = cur
+		}
+		cur.Up += up
+		cur.Down += down
+	}
+
+	transferLast[hash] = [2]int64{upTotal, downTotal}
+}
+
+// closeTransfer flushes the torrent's in-progress hour and forgets its
+// counters, called when the torrent stops running
+func closeTransfer(hash string) {
+	transferMu.Lock()
+	defer transferMu.Unlock()
+
+	if cur := transferNow[hash]; cur != nil && (cur.Up > 0 || cur.Down > 0) {
+		flushTransferSample(hash, cur)
+	}
+	delete(transferNow, hash)
+	delete(transferLast, hash)
+}
+
+// flushTransferSample appends the sample to the torrent's stored rollups,
+// the caller holds transferMu
+func flushTransferSample(hash string, sample *TransferSample) {
+	samples := getTransferHistory(hash)
+
+	// top up the stored sample when the process restarted mid-hour
+	if n := len(samples); n > 0 && samples[n-1].Time == sample.Time {
+		samples[n-1].Up += sample.Up
+		samples[n-1].Down += sample.Down
+	} else {
+		samples = append(samples, sample)
+	}
+
+	if len(samples) > maxTransferSamples {
+		samples = samples[len(samples)-maxTransferSamples:]
+	}
+
+	if err := db.Put(db.BucketTransfer, hash, samples); err != nil {
+		log.Printf("failed to save transfer history for %s: %v", hash, err)
+	}
+}
+
+// getTransferHistory returns the torrent's hourly samples, oldest first
+func getTransferHistory(hash string) []*TransferSample {
+	samples := []*TransferSample{}
+	if buf, err := db.Get(db.BucketTransfer, hash); err == nil {
+		if err := json.Unmarshal(buf, &samples); err != nil {
+			log.Printf("failed to read transfer history for %s: %v", hash, err)
+		}
+	}
+	return samples
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+
+	socket "github.com/akillmer/go-socket"
+	db "github.com/akillmer/riptide/database"
+)
+
+// UIConfig is a server-held settings block the frontend fetches on connect,
+// so multiple UIs can agree on formatting and features without hardcoding.
+type UIConfig struct {
+	// ByteUnits is "binary" for KiB/MiB or "decimal" for kB/MB
+	ByteUnits string `json:"byteUnits"`
+	// DateFormat in Go reference-time layout
+	DateFormat string `json:"dateFormat"`
+	// PageSize is the default page size for torrent lists
+	PageSize int `json:"pageSize"`
+	// SortBy and SortDesc set the order torrent lists and the init payload
+	// come back in when a request doesn't ask for one, so the browser never
+	// has to re-sort the full collection itself
+	SortBy   string `json:"sortBy"`
+	SortDesc bool   `json:"sortDesc"`
+	// Features toggles optional UI surfaces by name
+	Features map[string]bool `json:"features"`
+}
+
+const uiConfigKey = "uiConfig"
+
+// defaultUIConfig is what clients get until an admin changes anything
+func defaultUIConfig() *UIConfig {
+	return &UIConfig{
+		ByteUnits:  "binary",
+		DateFormat: "2006-01-02 15:04",
+		PageSize:   defaultPageSize,
+		SortBy:     "timeAdded",
+		Features: map[string]bool{
+			"search": true,
+			"rss":    false,
+		},
+	}
+}
+
+// getUIConfig returns the stored settings block, with defaults filling any
+// holes left by older versions
+func getUIConfig() *UIConfig {
+	cfg := defaultUIConfig()
+	if buf, err := db.Get(db.BucketSettings, uiConfigKey); err == nil {
+		json.Unmarshal(buf, cfg)
+	}
+	if cfg.ByteUnits == "" {
+		cfg.ByteUnits = "binary"
+	}
+	if cfg.PageSize <= 0 {
+		cfg.PageSize = defaultPageSize
+	}
+	if cfg.SortBy == "" {
+		cfg.SortBy = "timeAdded"
+	}
+	return cfg
+}
+
+// setUIConfig validates and stores the settings block, then pushes it to
+// every connected client
+func setUIConfig(payload interface{}) error {
+	cfg := getUIConfig()
+	if err := decodePayload(payload, cfg); err != nil {
+		return err
+	}
+
+	if cfg.ByteUnits != "binary" && cfg.ByteUnits != "decimal" {
+		return errors.New("byteUnits must be binary or decimal")
+	}
+	if cfg.PageSize <= 0 || cfg.PageSize > 1000 {
+		return errors.New("pageSize must be between 1 and 1000")
+	}
+	if !validSortKey(cfg.SortBy) {
+		return errors.New("sortBy must be timeAdded, name, size, or status")
+	}
+
+	if err := db.Put(db.BucketSettings, uiConfigKey, cfg); err != nil {
+		return err
+	}
+	return socket.Broadcast(MsgUIConfig, cfg)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/akillmer/riptide/fs"
+)
+
+// Post-processing for completed torrents: labels can opt in to having
+// RAR/ZIP archives in the payload extracted next to themselves once the
+// data has settled into its MoveTo destination. ZIPs are handled in-process,
+// RARs shell out to the system's unrar. Extraction state rides along in
+// TorrentInfo so clients can show it.
+
+// Unpack states stored in TorrentInfo.UnpackStatus
+const (
+	UnpackRunning = "unpacking"
+	UnpackDone    = "done"
+	UnpackFailed  = "failed"
+)
+
+// maybeUnpack kicks off extraction for a just-completed torrent when its
+// label opts in, it only ever runs once per torrent
+func maybeUnpack(hash string) {
+	info, err := GetTorrentInfo(hash)
+	if err != nil {
+		return
+	}
+
+	label := labelFor(info)
+	if label == nil || !label.Unpack || info.UnpackStatus != "" {
+		return
+	}
+
+	archives := findArchives(info.DataPath())
+	if len(archives) == 0 {
+		return
+	}
+
+	UpdateTorrent(hash, func(info *TorrentInfo) error {
+		info.UnpackStatus = UnpackRunning
+		return nil
+	})
+	go unpackTorrent(hash, info.DataPath(), archives, label.UnpackCleanup)
+}
+
+// findArchives returns the payload's top-level archive files. Multi-part
+// RAR sets only list their first volume, unrar follows the rest itself.
+func findArchives(dir string) []string {
+	var archives []string
+	fs.Default.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		switch strings.ToLower(filepath.Ext(p)) {
+		case ".zip", ".rar":
+			archives = append(archives, p)
+		}
+		return nil
+	})
+	return archives
+}
+
+func unpackTorrent(hash, dir string, archives []string, cleanup bool) {
+	var firstErr error
+	for _, archive := range archives {
+		var err error
+		switch strings.ToLower(filepath.Ext(archive)) {
+		case ".zip":
+			err = extractZip(archive, filepath.Dir(archive))
+		case ".rar":
+			err = extractRar(archive, filepath.Dir(archive))
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if firstErr != nil {
+		UpdateTorrent(hash, func(info *TorrentInfo) error {
+			info.UnpackStatus = UnpackFailed
+			return nil
+		})
+		appendHistory(hash, "unpack failed", firstErr.Error())
+		return
+	}
+
+	err := UpdateTorrent(hash, func(info *TorrentInfo) error {
+		info.UnpackStatus = UnpackDone
+
+		// archives can only go once nothing seeds them anymore, the
+		// symlinked originals are what the client is still serving
+		if cleanup && info.Status != StatusSeeding {
+			for _, archive := range archives {
+				fs.Default.Remove(archive)
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		appendHistory(hash, "unpacked", fmt.Sprintf("%d archives", len(archives)))
+	}
+}
+
+// extractZip inflates the archive into dest, refusing entries that would
+// escape it
+func extractZip(archive, dest string) error {
+	r, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	for _, f := range r.File {
+		name := filepath.Clean(f.Name)
+		if name == ".." || strings.HasPrefix(name, ".."+string(os.PathSeparator)) || path.IsAbs(f.Name) {
+			return fmt.Errorf("archive entry escapes destination: %s", f.Name)
+		}
+
+		target := filepath.Join(dest, name)
+		if f.FileInfo().IsDir() {
+			if err := fs.Default.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := fs.Default.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := copyZipEntry(f, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyZipEntry(f *zip.File, target string) error {
+	src, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+// extractRar shells out to the system's unrar, the only practical way to
+// handle the format
+func extractRar(archive, dest string) error {
+	unrar, err := exec.LookPath("unrar")
+	if err != nil {
+		return fmt.Errorf("unrar is not installed, cannot extract %s", filepath.Base(archive))
+	}
+
+	out, err := exec.Command(unrar, "x", "-o+", "-y", archive, dest+string(os.PathSeparator)).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("unrar failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
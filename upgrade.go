@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	db "github.com/akillmer/riptide/database"
+)
+
+// allowUpgrade is the -allow-upgrade opt-in, remote upgrades are refused
+// without it. The websocket itself carries no login, so an always-on binary
+// swap would hand code execution to anyone who can reach it.
+var allowUpgrade bool
+
+// UpgradePayload for MsgServerUpgrade. The checksum is mandatory, a binary
+// swap without one is how servers get owned. Auth carries the -files-auth
+// user:pass and is required whenever that flag is set.
+type UpgradePayload struct {
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256"`
+	Auth   string `json:"auth,omitempty"`
+}
+
+// upgradeServer downloads a release binary, verifies its checksum, swaps it
+// over the running executable, and schedules a restart. The restart happens
+// shortly after returning so the caller's ack still goes out.
+func upgradeServer(data *UpgradePayload) error {
+	if !allowUpgrade {
+		return errors.New("remote upgrade is disabled, start the server with -allow-upgrade")
+	}
+	if filesAuth != "" && subtle.ConstantTimeCompare([]byte(data.Auth), []byte(filesAuth)) != 1 {
+		return errors.New("upgrade requires the -files-auth credentials")
+	}
+
+	if data.URL == "" || data.SHA256 == "" {
+		return ErrBadRequest
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.Get(data.URL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("upgrade download returned %s", resp.Status)
+	}
+
+	tmpPath := exe + ".upgrade"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, sum), resp.Body); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if got := hex.EncodeToString(sum.Sum(nil)); !strings.EqualFold(got, data.SHA256) {
+		os.Remove(tmpPath)
+		return errors.New("upgrade checksum mismatch")
+	}
+
+	if err := os.Rename(tmpPath, exe); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	log.Printf("upgraded binary in place, restarting")
+	go func() {
+		// let the ack reach the client before the process is replaced
+		time.Sleep(time.Second)
+		sdNotify("RELOADING=1")
+		db.Close()
+		if err := syscall.Exec(exe, os.Args, os.Environ()); err != nil {
+			log.Fatalf("failed to restart after upgrade: %v", err)
+		}
+	}()
+
+	return nil
+}
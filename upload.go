@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Batch .torrent uploads over HTTP. Dropping a folder of torrents onto the
+// UI would otherwise cost one websocket round trip per file; here the whole
+// batch arrives as one multipart POST and each file gets its own verdict.
+// For flaky links a file can instead be streamed in resumable chunks keyed
+// by a client-chosen upload ID:
+//
+//	PUT  <base>/upload/chunk?id=X&offset=N       append a chunk at offset N
+//	GET  <base>/upload/chunk?id=X                current offset, to resume
+//	PUT  ...&offset=N&done=1&name=file.torrent   final chunk, enqueues it
+//
+// A PUT at the wrong offset answers 409 with the expected offset in the
+// X-Upload-Offset header.
+
+// UploadResult is one file's verdict: ADDED, DUPLICATE, or ERROR
+type UploadResult struct {
+	Name   string `json:"name"`
+	Hash   string `json:"hash,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// uploadIDPattern keeps client-chosen IDs safe to use in temp file names
+var uploadIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// uploadMu serializes chunk appends per process, uploads are rare and small
+// enough that one lock over all of them is fine
+var uploadMu sync.Mutex
+
+// maxTorrentFileSize caps one .torrent upload, metainfo past this is junk
+const maxTorrentFileSize = 16 << 20
+
+// enqueueTorrentFile parses one uploaded .torrent and adds it by magnet,
+// the same path every torrent enters the client through
+func enqueueTorrentFile(name string, r io.Reader) *UploadResult {
+	result := &UploadResult{Name: name}
+
+	mi, err := metainfo.Load(io.LimitReader(r, maxTorrentFileSize))
+	if err != nil {
+		result.Status = "ERROR"
+		result.Error = fmt.Sprintf("not a torrent file: %v", err)
+		return result
+	}
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		result.Status = "ERROR"
+		result.Error = fmt.Sprintf("bad info dictionary: %v", err)
+		return result
+	}
+
+	hash := mi.HashInfoBytes()
+	result.Hash = hash.String()
+
+	if err := addTorrent(&TorrentAddPayload{Magnet: mi.Magnet(info.Name, hash).String()}); err != nil {
+		if _, ok := err.(*ConflictError); ok {
+			result.Status = "DUPLICATE"
+		} else {
+			result.Status = "ERROR"
+			result.Error = err.Error()
+		}
+		return result
+	}
+
+	result.Status = "ADDED"
+	return result
+}
+
+// handleUploadBatch takes a multipart form and enqueues every file part
+func handleUploadBatch(w http.ResponseWriter, r *http.Request) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "expected multipart form", http.StatusBadRequest)
+		return
+	}
+
+	results := []*UploadResult{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if part.FileName() == "" {
+			continue
+		}
+		results = append(results, enqueueTorrentFile(part.FileName(), part))
+	}
+
+	writeUploadJSON(w, results)
+}
+
+// uploadChunkPath is where a partial upload accumulates between requests
+func uploadChunkPath(id string) string {
+	return filepath.Join(os.TempDir(), "riptide-upload-"+id)
+}
+
+// handleUploadChunk appends one chunk to a resumable upload, finishing it
+// when the request says it's done
+func handleUploadChunk(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if !uploadIDPattern.MatchString(id) {
+		http.Error(w, "bad upload id", http.StatusBadRequest)
+		return
+	}
+	chunkPath := uploadChunkPath(id)
+
+	uploadMu.Lock()
+	defer uploadMu.Unlock()
+
+	var size int64
+	if fi, err := os.Stat(chunkPath); err == nil {
+		size = fi.Size()
+	}
+
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"offset":%d}`, size)
+		return
+	}
+	if r.Method != http.MethodPut {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	if err != nil || offset != size {
+		w.Header().Set("X-Upload-Offset", strconv.FormatInt(size, 10))
+		http.Error(w, "offset mismatch", http.StatusConflict)
+		return
+	}
+	if size >= maxTorrentFileSize {
+		os.Remove(chunkPath)
+		http.Error(w, "upload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	f, err := os.OpenFile(chunkPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_, err = io.Copy(f, io.LimitReader(r.Body, maxTorrentFileSize-size))
+	f.Close()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("done") == "" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	buf, err := os.ReadFile(chunkPath)
+	os.Remove(chunkPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		name = id
+	}
+	writeUploadJSON(w, []*UploadResult{enqueueTorrentFile(name, bytes.NewReader(buf))})
+}
+
+func writeUploadJSON(w http.ResponseWriter, results []*UploadResult) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// mountUploads registers the batch and chunk handlers at prefix
+func mountUploads(prefix string) {
+	http.HandleFunc(prefix, func(w http.ResponseWriter, r *http.Request) {
+		if !checkFilesAuth(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="riptide"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		handleUploadBatch(w, r)
+	})
+
+	http.HandleFunc(prefix+"/chunk", func(w http.ResponseWriter, r *http.Request) {
+		if !checkFilesAuth(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="riptide"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handleUploadChunk(w, r)
+	})
+}
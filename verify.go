@@ -0,0 +1,82 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// verifyInterval is how often one long-term seed gets its data re-hashed,
+// set from the -verify-hours flag, zero disables re-verification
+var verifyInterval time.Duration
+
+// watchVerify periodically re-verifies seeded data against its piece hashes
+// so bit rot or partial deletion raises a torrent error here, before a
+// tracker flags us for sending bad data. One torrent per interval keeps the
+// disk load bounded.
+func watchVerify() {
+	if verifyInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(verifyInterval)
+	for range ticker.C {
+		verifyNextSeed()
+	}
+}
+
+// verifyNextSeed re-verifies the running seed that has gone the longest
+// without a check
+func verifyNextSeed() {
+	allInfo, err := GetAllTorrentInfo()
+	if err != nil {
+		log.Printf("failed to pick a torrent to verify: %v", err)
+		return
+	}
+
+	var pick *TorrentInfo
+	for _, info := range allInfo {
+		if info.Status != StatusSeeding && info.Status != StatusDone {
+			continue
+		}
+		// only torrents loaded in the client can be verified
+		if _, running := managedTorrents.Load(info.Hash); !running {
+			continue
+		}
+		if pick == nil || info.LastVerified < pick.LastVerified {
+			pick = info
+		}
+	}
+
+	if pick != nil {
+		verifyTorrent(pick.Hash)
+	}
+}
+
+// verifyTorrent re-hashes every piece of a torrent the client is holding and
+// flags the torrent when any of its data no longer matches
+func verifyTorrent(hash string) {
+	t, ok := client.Torrent(metainfo.NewHashFromHex(hash))
+	if !ok || t.Info() == nil {
+		return
+	}
+
+	log.Printf("verifying seeded data for %s", hash)
+	t.VerifyData() // blocks while the client re-hashes every piece
+
+	err := UpdateTorrent(hash, func(info *TorrentInfo) error {
+		info.LastVerified = time.Now().Unix()
+		if t.BytesCompleted() < t.Length() {
+			info.Status = StatusError
+			info.Error = "data failed verification"
+			appendHistory(hash, "errored", info.Error)
+		} else {
+			appendHistory(hash, "verified", "")
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("failed to record verification for %s: %v", hash, err)
+	}
+}
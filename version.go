@@ -0,0 +1,20 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Build information, overridden by the release script:
+//
+//	go build -ldflags "-X main.Version=v1.2.3 -X main.BuildStamp=2019-01-02"
+var (
+	Version    = "unknown-dev"
+	BuildStamp = "unknown"
+)
+
+// LongVersion is the full identification string served as MsgServerVersion
+func LongVersion() string {
+	return fmt.Sprintf("riptide %s (%s %s-%s) %s",
+		Version, runtime.Version(), runtime.GOOS, runtime.GOARCH, BuildStamp)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Connection webhooks for external dashboards. With -webhook set, the URL
+// gets one JSON POST per event — clients connecting and disconnecting, DHT
+// bootstrap results, torrents erroring — so Grafana or Uptime Kuma can alert
+// on riptide health without polling the websocket API. Delivery is fire and
+// forget: a slow collector loses events, never the server's time.
+
+// webhookURL is the -webhook flag, empty disables event delivery
+var webhookURL string
+
+// WebhookEvent is one JSON notification POSTed to the webhook URL
+type WebhookEvent struct {
+	Event string `json:"event"`
+	// Subject is what the event is about, a client ID or a torrent hash
+	Subject string `json:"subject,omitempty"`
+	Detail  string `json:"detail,omitempty"`
+	Time    int64  `json:"time"`
+}
+
+// webhookQueue buffers events for the single delivery goroutine
+var webhookQueue = make(chan *WebhookEvent, 64)
+
+// fireWebhook queues an event for delivery, dropping it when the queue is
+// full so a dead collector can't back the callers up
+func fireWebhook(event, subject, detail string) {
+	if webhookURL == "" {
+		return
+	}
+
+	select {
+	case webhookQueue <- &WebhookEvent{
+		Event:   event,
+		Subject: subject,
+		Detail:  detail,
+		Time:    time.Now().Unix(),
+	}:
+	default:
+	}
+}
+
+// runWebhooks delivers queued events one at a time with a short timeout
+func runWebhooks() {
+	httpClient := &http.Client{Timeout: 5 * time.Second}
+
+	for ev := range webhookQueue {
+		buf, err := json.Marshal(ev)
+		if err != nil {
+			continue
+		}
+
+		resp, err := httpClient.Post(webhookURL, "application/json", bytes.NewReader(buf))
+		if err != nil {
+			log.Printf("webhook delivery failed: %v", err)
+			continue
+		}
+		resp.Body.Close()
+	}
+}
+
+// clientOpened is the socket's OnOpen hook
+func clientOpened(clientID string) {
+	fireWebhook("client.connected", clientID, "")
+	initDataWithClient(clientID)
+}
+
+// clientClosed is the socket's OnClose hook, it also forgets whatever
+// features the client negotiated
+func clientClosed(clientID string) {
+	clientFeatures.Delete(clientID)
+	fireWebhook("client.disconnected", clientID, "")
+}